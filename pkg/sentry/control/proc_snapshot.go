@@ -0,0 +1,31 @@
+// Copyright 2026 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package control
+
+import (
+	"gvisor.dev/gvisor/pkg/context"
+	"gvisor.dev/gvisor/pkg/sentry/kernel"
+)
+
+// Snapshot is a urpc method on Proc that returns a structured, point-in-time
+// snapshot of the sandbox's entire process tree (every PID namespace,
+// thread group, and task), for operator tooling that wants a coherent view
+// without scraping /proc or racing with concurrent fork/exit. It is the
+// structured counterpart to Processes, which instead returns a flat,
+// human-readable table scoped to a single container.
+func (proc *Proc) Snapshot(_ *struct{}, out *[]kernel.PIDNamespaceSnapshot) error {
+	*out = proc.Kernel.TaskSet().Snapshot(context.Background())
+	return nil
+}
@@ -0,0 +1,293 @@
+// Copyright 2026 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// cryptBlockPlaintextSize is the amount of plaintext file content
+	// packed into a single on-disk ciphertext block. Content encryption
+	// operates on fixed-size blocks (rather than encrypting the whole file
+	// as one AEAD message) so that a random-offset write only has to
+	// decrypt, modify, and re-encrypt the handful of blocks it actually
+	// touches, following gocryptfs's content encryption design.
+	cryptBlockPlaintextSize = 4096
+
+	// cryptNonceSize is the size of the random nonce prepended to each
+	// on-disk block. Only the leading aeadNonceSize (12) bytes are actually
+	// fed to AES-GCM as its nonce (see gcmNonce); the birthday bound on
+	// nonce collision is therefore the usual 12-byte one, not widened by
+	// the extra 4 bytes. Those extra bytes are still worth keeping: they're
+	// bound into the block's additional authenticated data (see blockAAD),
+	// so a block can't be replayed with a stripped-down nonce.
+	cryptNonceSize = 16
+
+	// cryptTagSize is the size of the AES-GCM authentication tag appended
+	// to each on-disk block.
+	cryptTagSize = 16
+
+	// cryptBlockCiphertextSize is the on-disk size of a single block:
+	// nonce + plaintext + tag.
+	cryptBlockCiphertextSize = cryptNonceSize + cryptBlockPlaintextSize + cryptTagSize
+)
+
+// cryptFileKey is the per-file AES-GCM key used for content encryption,
+// derived from the mount's key store and the file's inode key so that two
+// files never share a key even if their plaintext happens to collide.
+type cryptFileKey struct {
+	aead cipher.AEAD
+}
+
+// newCryptFileKey constructs the AEAD used to encrypt/decrypt blocks of a
+// single file, given that file's 32-byte derived key.
+func newCryptFileKey(key []byte) (*cryptFileKey, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cryptfs: creating AES cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("cryptfs: creating GCM AEAD: %w", err)
+	}
+	return &cryptFileKey{aead: aead}, nil
+}
+
+// blockRange returns the inclusive range of on-disk block indices that
+// cover the cleartext byte range [offset, offset+length), and the byte
+// offset within the first such block at which the requested range begins.
+func blockRange(offset, length uint64) (firstBlock, lastBlock uint64, offsetInFirstBlock uint64) {
+	firstBlock = offset / cryptBlockPlaintextSize
+	if length == 0 {
+		return firstBlock, firstBlock, offset % cryptBlockPlaintextSize
+	}
+	lastBlock = (offset + length - 1) / cryptBlockPlaintextSize
+	return firstBlock, lastBlock, offset % cryptBlockPlaintextSize
+}
+
+// ciphertextRange returns the byte range in ciphertext-space (i.e. in the
+// on-disk, block-packed file) that must be read or written on the host in
+// order to service a cleartext access of [offset, offset+length). Because
+// blocks are fixed-size, this is simply the byte extent of every block
+// touched by the cleartext range, never a sub-block slice: callers read (or
+// read-modify-write) whole blocks and then slice the plaintext themselves.
+func ciphertextRange(offset, length uint64) (ciphertextOffset, ciphertextLength uint64) {
+	firstBlock, lastBlock, _ := blockRange(offset, length)
+	ciphertextOffset = firstBlock * cryptBlockCiphertextSize
+	ciphertextLength = (lastBlock - firstBlock + 1) * cryptBlockCiphertextSize
+	return ciphertextOffset, ciphertextLength
+}
+
+// encryptBlock seals plaintext (which must be at most
+// cryptBlockPlaintextSize bytes; shorter only for the file's final,
+// partially-filled block) into an on-disk block: a fresh random nonce,
+// followed by the AES-GCM-sealed ciphertext and tag.
+func (k *cryptFileKey) encryptBlock(blockIndex uint64, plaintext []byte) ([]byte, error) {
+	if len(plaintext) > cryptBlockPlaintextSize {
+		return nil, fmt.Errorf("cryptfs: block %d plaintext of %d bytes exceeds block size %d", blockIndex, len(plaintext), cryptBlockPlaintextSize)
+	}
+	nonce := make([]byte, cryptNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("cryptfs: generating nonce: %w", err)
+	}
+	// The block index and the nonce bytes GCM itself doesn't consume are
+	// bound into the AEAD's additional data, rather than relied upon only
+	// implicitly via block position, so that blocks can't be silently
+	// reordered or spliced between files/offsets/nonces without failing
+	// authentication.
+	aad := blockAAD(blockIndex, nonce[k.aead.NonceSize():])
+	sealed := k.aead.Seal(nil, gcmNonce(nonce, k.aead.NonceSize()), plaintext, aad)
+	out := make([]byte, 0, cryptNonceSize+len(sealed))
+	out = append(out, nonce...)
+	out = append(out, sealed...)
+	return out, nil
+}
+
+// decryptBlock is the inverse of encryptBlock: it authenticates and opens
+// block (a full on-disk block, nonce+ciphertext+tag) and returns its
+// plaintext, which is at most cryptBlockPlaintextSize bytes.
+func (k *cryptFileKey) decryptBlock(blockIndex uint64, block []byte) ([]byte, error) {
+	if len(block) < cryptNonceSize+cryptTagSize {
+		return nil, fmt.Errorf("cryptfs: block %d of %d bytes is too short to contain a nonce and tag", blockIndex, len(block))
+	}
+	nonce := block[:cryptNonceSize]
+	sealed := block[cryptNonceSize:]
+	aad := blockAAD(blockIndex, nonce[k.aead.NonceSize():])
+	plaintext, err := k.aead.Open(nil, gcmNonce(nonce, k.aead.NonceSize()), sealed, aad)
+	if err != nil {
+		return nil, fmt.Errorf("cryptfs: authenticating block %d: %w", blockIndex, err)
+	}
+	return plaintext, nil
+}
+
+// decryptRange decrypts ciphertext (the on-disk bytes covering the block
+// range ciphertextRange(offset, length) returns, possibly short at the end
+// if the file's last block runs past EOF) and returns the requested
+// cleartext byte range, trimmed to whatever ciphertext actually covers.
+func (k *cryptFileKey) decryptRange(offset, length uint64, ciphertext []byte) ([]byte, error) {
+	if length == 0 {
+		return nil, nil
+	}
+	firstBlock, lastBlock, skip := blockRange(offset, length)
+	out := make([]byte, 0, length)
+	need := length
+	for block := firstBlock; block <= lastBlock && need > 0; block++ {
+		start := (block - firstBlock) * cryptBlockCiphertextSize
+		end := start + cryptBlockCiphertextSize
+		if end > uint64(len(ciphertext)) {
+			end = uint64(len(ciphertext))
+		}
+		if start >= end {
+			break
+		}
+		plaintext, err := k.decryptBlock(block, ciphertext[start:end])
+		if err != nil {
+			return nil, err
+		}
+		if skip > uint64(len(plaintext)) {
+			skip = uint64(len(plaintext))
+		}
+		avail := plaintext[skip:]
+		if uint64(len(avail)) > need {
+			avail = avail[:need]
+		}
+		out = append(out, avail...)
+		need -= uint64(len(avail))
+		skip = 0
+	}
+	return out, nil
+}
+
+// encryptRange produces the new on-disk ciphertext for the block range
+// ciphertextRange(offset, len(plaintext)) covers, splicing plaintext into
+// whatever existingCiphertext (the blocks previously on disk at that same
+// range, or nil/short past the current EOF) holds. A write that doesn't
+// start or end on a block boundary still has to preserve the untouched
+// bytes on either side of it within its first and last block, since both
+// get a fresh nonce and AEAD seal regardless of how much of the block
+// actually changed.
+func (k *cryptFileKey) encryptRange(offset uint64, plaintext []byte, existingCiphertext []byte) ([]byte, error) {
+	if len(plaintext) == 0 {
+		return nil, nil
+	}
+	firstBlock, lastBlock, offsetInFirstBlock := blockRange(offset, uint64(len(plaintext)))
+	out := make([]byte, 0, (lastBlock-firstBlock+1)*cryptBlockCiphertextSize)
+	consumed := uint64(0)
+	for block := firstBlock; block <= lastBlock; block++ {
+		blockStart := uint64(0)
+		if block == firstBlock {
+			blockStart = offsetInFirstBlock
+		}
+		n := uint64(len(plaintext)) - consumed
+		if blockCap := uint64(cryptBlockPlaintextSize) - blockStart; n > blockCap {
+			n = blockCap
+		}
+		merged := make([]byte, blockStart+n)
+		cstart := (block - firstBlock) * cryptBlockCiphertextSize
+		cend := cstart + cryptBlockCiphertextSize
+		if cend > uint64(len(existingCiphertext)) {
+			cend = uint64(len(existingCiphertext))
+		}
+		if cstart < cend {
+			old, err := k.decryptBlock(block, existingCiphertext[cstart:cend])
+			if err != nil {
+				return nil, err
+			}
+			copy(merged, old)
+			if uint64(len(old)) > uint64(len(merged)) {
+				merged = append(merged, old[len(merged):]...)
+			}
+		}
+		copy(merged[blockStart:], plaintext[consumed:consumed+n])
+		sealed, err := k.encryptBlock(block, merged)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sealed...)
+		consumed += n
+	}
+	return out, nil
+}
+
+// gcmNonce derives the nonce actually passed to AES-GCM (which requires
+// exactly aeadNonceSize bytes) from our on-disk cryptNonceSize-byte random
+// nonce, by truncating to the leading aeadNonceSize bytes. This does not
+// widen the birthday bound on nonce reuse (that's still governed by the
+// aeadNonceSize bytes actually fed to GCM); see blockAAD for what the
+// discarded trailing bytes are used for instead.
+func gcmNonce(nonce []byte, aeadNonceSize int) []byte {
+	return nonce[:aeadNonceSize]
+}
+
+// blockAAD returns the additional authenticated data bound into block
+// blockIndex's AES-GCM seal: the block index, followed by extra (the
+// nonce bytes beyond what GCM consumes as its own nonce, so the full
+// on-disk nonce is authenticated even though only part of it is used for
+// GCM's uniqueness guarantee).
+func blockAAD(blockIndex uint64, extra []byte) []byte {
+	aad := make([]byte, 8, 8+len(extra))
+	for i := 0; i < 8; i++ {
+		aad[i] = byte(blockIndex >> (8 * uint(i)))
+	}
+	return append(aad, extra...)
+}
+
+// deriveFileKey derives the 32-byte AES-256 key for a single file from the
+// mount-wide master key and the file's inode key (its lisafs/directfs inode
+// identity), via HKDF, so that every file gets an independent key without
+// needing its own entry in the keyfile.
+func deriveFileKey(masterKey []byte, inoKey inoKey) ([]byte, error) {
+	info := fmt.Sprintf("gvisor-cryptfs-file-key:%v", inoKey)
+	h := hkdf.New(sha256.New, masterKey, nil, []byte(info))
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(h, key); err != nil {
+		return nil, fmt.Errorf("cryptfs: deriving file key: %w", err)
+	}
+	return key, nil
+}
+
+// deriveMasterKeyFromPassphrase derives the mount-wide master key ("key
+// encryption key", or KEK, in gocryptfs terminology) from a passphrase read
+// out of the mount's keyfile, using scrypt to make brute-forcing the
+// passphrase expensive even if the keyfile is exposed.
+//
+// deriveMasterKeyFromPassphrase has no callers in this checkout: reading
+// the keyfile and constructing the filesystem's root *cryptfsDentry is mount
+// setup, which belongs in filesystem.go (not part of this checkout), so a
+// cryptfs mount can't actually be created yet.
+func deriveMasterKeyFromPassphrase(passphrase, salt []byte) ([]byte, error) {
+	// N=2^15, r=8, p=1 matches gocryptfs's default scrypt parameters: a
+	// reasonable balance between unlock latency and brute-force cost for a
+	// mount operation that happens once per sandbox startup.
+	const (
+		scryptN = 1 << 15
+		scryptR = 8
+		scryptP = 1
+	)
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, fmt.Errorf("cryptfs: deriving master key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,286 @@
+// Copyright 2026 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofer
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func newTestFileKey(t *testing.T) *cryptFileKey {
+	t.Helper()
+	masterKey := bytes.Repeat([]byte{0x42}, 32)
+	fileKey, err := deriveFileKey(masterKey, inoKey{ino: 7})
+	if err != nil {
+		t.Fatalf("deriveFileKey: %v", err)
+	}
+	k, err := newCryptFileKey(fileKey)
+	if err != nil {
+		t.Fatalf("newCryptFileKey: %v", err)
+	}
+	return k
+}
+
+// TestBlockRangeRandomOffsets exercises the cleartext->ciphertext offset
+// translation used by the read/write paths at a variety of random offsets
+// and lengths, checking that every byte of the requested range falls within
+// the block range reported.
+func TestBlockRangeRandomOffsets(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		offset := uint64(rng.Intn(10 * cryptBlockPlaintextSize))
+		length := uint64(1 + rng.Intn(4*cryptBlockPlaintextSize))
+
+		firstBlock, lastBlock, offsetInFirstBlock := blockRange(offset, length)
+		if got := firstBlock*cryptBlockPlaintextSize + offsetInFirstBlock; got != offset {
+			t.Fatalf("offset %d length %d: firstBlock*%d+offsetInFirstBlock = %d, want %d", offset, length, cryptBlockPlaintextSize, got, offset)
+		}
+		lastByte := offset + length - 1
+		if lastByte/cryptBlockPlaintextSize != lastBlock {
+			t.Fatalf("offset %d length %d: lastBlock = %d, want %d", offset, length, lastBlock, lastByte/cryptBlockPlaintextSize)
+		}
+
+		cipherOffset, cipherLength := ciphertextRange(offset, length)
+		if wantOffset := firstBlock * cryptBlockCiphertextSize; cipherOffset != wantOffset {
+			t.Fatalf("offset %d length %d: ciphertextRange offset = %d, want %d", offset, length, cipherOffset, wantOffset)
+		}
+		if wantBlocks := lastBlock - firstBlock + 1; cipherLength != wantBlocks*cryptBlockCiphertextSize {
+			t.Fatalf("offset %d length %d: ciphertextRange length = %d, want %d blocks worth", offset, length, cipherLength, wantBlocks)
+		}
+	}
+}
+
+// TestEncryptDecryptBlockRoundTrip writes and reads back blocks at random
+// sizes (including the file's final, partially-filled block) and checks
+// that decryptBlock recovers the original plaintext and rejects tampering.
+func TestEncryptDecryptBlockRoundTrip(t *testing.T) {
+	k := newTestFileKey(t)
+	rng := rand.New(rand.NewSource(2))
+	for blockIndex := uint64(0); blockIndex < 16; blockIndex++ {
+		plaintext := make([]byte, 1+rng.Intn(cryptBlockPlaintextSize))
+		rng.Read(plaintext)
+
+		block, err := k.encryptBlock(blockIndex, plaintext)
+		if err != nil {
+			t.Fatalf("block %d: encryptBlock: %v", blockIndex, err)
+		}
+		if len(block) != cryptNonceSize+len(plaintext)+cryptTagSize {
+			t.Fatalf("block %d: on-disk size = %d, want %d", blockIndex, len(block), cryptNonceSize+len(plaintext)+cryptTagSize)
+		}
+
+		got, err := k.decryptBlock(blockIndex, block)
+		if err != nil {
+			t.Fatalf("block %d: decryptBlock: %v", blockIndex, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Fatalf("block %d: decrypted %v, want %v", blockIndex, got, plaintext)
+		}
+
+		// Decrypting under the wrong block index (simulating a block spliced
+		// in from elsewhere in the file) must fail authentication.
+		if _, err := k.decryptBlock(blockIndex+1, block); err == nil {
+			t.Fatalf("block %d: decryptBlock under wrong index unexpectedly succeeded", blockIndex)
+		}
+	}
+}
+
+// TestEncryptDecryptRangeRoundTrip exercises the read-modify-write logic
+// dentry.readAt/writeAt build on: encryptRange must preserve the bytes
+// surrounding a partial, non-block-aligned overwrite, and decryptRange must
+// recover exactly the requested cleartext range afterwards, whether or not
+// it lines up with block boundaries.
+func TestEncryptDecryptRangeRoundTrip(t *testing.T) {
+	k := newTestFileKey(t)
+	rng := rand.New(rand.NewSource(3))
+
+	// Write the file's initial content in one shot, several blocks long.
+	const fileSize = 3*cryptBlockPlaintextSize + 500
+	original := make([]byte, fileSize)
+	rng.Read(original)
+	ciphertext, err := k.encryptRange(0, original, nil)
+	if err != nil {
+		t.Fatalf("encryptRange(initial write): %v", err)
+	}
+
+	// Overwrite a range that starts and ends mid-block, straddling two
+	// block boundaries, and check that the bytes on either side of it
+	// within their blocks survive the round trip unchanged.
+	const overwriteOffset = cryptBlockPlaintextSize - 50
+	overwrite := make([]byte, cryptBlockPlaintextSize+100)
+	rng.Read(overwrite)
+
+	existingCiphertextOffset, existingCiphertextLength := ciphertextRange(overwriteOffset, uint64(len(overwrite)))
+	existing := ciphertext[existingCiphertextOffset : existingCiphertextOffset+existingCiphertextLength]
+	newCiphertext, err := k.encryptRange(overwriteOffset, overwrite, existing)
+	if err != nil {
+		t.Fatalf("encryptRange(overwrite): %v", err)
+	}
+	copy(ciphertext[existingCiphertextOffset:], newCiphertext)
+
+	want := append([]byte(nil), original...)
+	copy(want[overwriteOffset:], overwrite)
+
+	got, err := k.decryptRange(0, fileSize, ciphertext)
+	if err != nil {
+		t.Fatalf("decryptRange(whole file): %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("decrypted content after partial overwrite at %d didn't match the expected merge of old and new bytes", overwriteOffset)
+	}
+
+	// A sub-range read not aligned to any block boundary must also recover
+	// exactly the requested bytes.
+	const subOffset = cryptBlockPlaintextSize + 10
+	const subLength = cryptBlockPlaintextSize + 200
+	subCipherOffset, subCipherLength := ciphertextRange(subOffset, subLength)
+	sub, err := k.decryptRange(subOffset, subLength, ciphertext[subCipherOffset:subCipherOffset+subCipherLength])
+	if err != nil {
+		t.Fatalf("decryptRange(sub-range): %v", err)
+	}
+	if !bytes.Equal(sub, want[subOffset:subOffset+subLength]) {
+		t.Fatalf("decryptRange(%d, %d) didn't match the corresponding slice of the full file", subOffset, subLength)
+	}
+}
+
+// TestTruncateGrowPastBlockBoundary exercises the zero-fill path
+// cryptfsDentry.setStatLocked and dentry.allocate route a growing
+// truncate/fallocate through: both now call dt.writeAt with a zero-filled
+// plaintext buffer covering the newly-exposed range (see dentry_impl.go),
+// rather than leaving the ciphertext short or fallocate(2)-zeroing it
+// directly, which would fail AES-GCM authentication on a later read. Since
+// dt.writeAt's own job beyond that call is just translating offset/length
+// via ciphertextRange and forwarding to a *handle (a type from a file
+// that, like dentry.go, isn't part of this checkout - see readHandle's
+// doc comment), this test drives the same encryptRange/decryptRange calls
+// writeAt makes directly, covering the part of the zero-fill path that is
+// actually ours to test here.
+func TestTruncateGrowPastBlockBoundary(t *testing.T) {
+	k := newTestFileKey(t)
+	rng := rand.New(rand.NewSource(4))
+
+	const oldSize = cryptBlockPlaintextSize - 10
+	const newSize = cryptBlockPlaintextSize*2 + 100
+
+	oldFirst, oldLast, _ := blockRange(0, oldSize)
+	if oldFirst != 0 || oldLast != 0 {
+		t.Fatalf("old size %d unexpectedly spans blocks [%d, %d]", oldSize, oldFirst, oldLast)
+	}
+	newFirst, newLast, _ := blockRange(0, newSize)
+	if newFirst != 0 || newLast != 2 {
+		t.Fatalf("new size %d spans blocks [%d, %d], want [0, 2]", newSize, newFirst, newLast)
+	}
+	if got := formatCryptSize(newSize); got != "8292" {
+		t.Fatalf("formatCryptSize(%d) = %q, want %q", newSize, got, "8292")
+	}
+
+	// Write the file's original content, then grow it exactly the way
+	// setStatLocked/allocate do: a zero-filled writeAt/encryptRange call
+	// covering [oldSize, newSize), merged against whatever ciphertext
+	// already exists there (none, here, since the file previously ended
+	// at oldSize).
+	original := make([]byte, oldSize)
+	rng.Read(original)
+	ciphertext, err := k.encryptRange(0, original, nil)
+	if err != nil {
+		t.Fatalf("encryptRange(initial write): %v", err)
+	}
+
+	growOffset, _ := ciphertextRange(oldSize, newSize-oldSize)
+	// existing only covers the ciphertext that actually exists (all of the
+	// last old block, here the file's only block); the same way a real writeAt passes existing[:n]
+	// after a short readToBlocksAt, the never-written blocks being grown
+	// into must not be presented as existing ciphertext to decrypt, or
+	// encryptRange would try (and fail) to authenticate zeros.
+	existing := ciphertext[growOffset:]
+	grown, err := k.encryptRange(oldSize, make([]byte, newSize-oldSize), existing)
+	if err != nil {
+		t.Fatalf("encryptRange(zero-fill grow): %v", err)
+	}
+	ciphertext = append(ciphertext[:growOffset], grown...)
+
+	got, err := k.decryptRange(0, newSize, ciphertext)
+	if err != nil {
+		t.Fatalf("decryptRange(whole grown file): %v", err)
+	}
+	want := append(append([]byte(nil), original...), make([]byte, newSize-oldSize)...)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("grown file content didn't decrypt to the original bytes followed by zeroes")
+	}
+}
+
+// TestNameCipherRoundTrip checks that every name encrypts to a distinct,
+// filesystem-safe on-disk name and decrypts back to its original cleartext,
+// which is what a rename across two directories in the same mount (sharing
+// one cryptNameCipher) relies on.
+func TestNameCipherRoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{0x17}, 64)
+	c, err := newCryptNameCipher(key)
+	if err != nil {
+		t.Fatalf("newCryptNameCipher: %v", err)
+	}
+
+	names := []string{"a", "file.txt", "very-long-name-with-many-characters-in-it", ""}
+	seen := make(map[string]string)
+	for _, name := range names {
+		enc := c.Encrypt(name)
+		if prev, ok := seen[enc]; ok && prev != name {
+			t.Fatalf("names %q and %q encrypted to the same on-disk name %q", prev, name, enc)
+		}
+		seen[enc] = name
+
+		dec, err := c.Decrypt(enc)
+		if err != nil {
+			t.Fatalf("Decrypt(%q): %v", enc, err)
+		}
+		if dec != name {
+			t.Fatalf("Decrypt(Encrypt(%q)) = %q", name, dec)
+		}
+	}
+}
+
+// TestRenameAcrossDirectoriesReusesCipher checks that encryptedName (the
+// helper dentry.rename's cryptfsDentry case uses for both the source and
+// destination directory) produces on-disk names that decrypt correctly
+// under the single cryptNameCipher shared by every directory in a mount,
+// regardless of which directory's cache recorded the mapping.
+func TestRenameAcrossDirectoriesReusesCipher(t *testing.T) {
+	keys := &cryptfsKeyStore{}
+	var err error
+	keys.nameCipher, err = newCryptNameCipher(bytes.Repeat([]byte{0x5a}, 64))
+	if err != nil {
+		t.Fatalf("newCryptNameCipher: %v", err)
+	}
+
+	srcDir := &cryptfsDentry{keys: keys}
+	dstDir := &cryptfsDentry{keys: keys}
+
+	const name = "moved-file"
+	encFromSrc := srcDir.encryptedName(name)
+	encFromDst := dstDir.encryptedName(name)
+	if encFromSrc != encFromDst {
+		t.Fatalf("encryptedName differs across directories sharing a key store: %q vs %q", encFromSrc, encFromDst)
+	}
+
+	// The destination directory's cache should resolve the moved name
+	// without consulting the source directory's cache.
+	got, err := dstDir.decryptedName(encFromSrc)
+	if err != nil {
+		t.Fatalf("dstDir.decryptedName: %v", err)
+	}
+	if got != name {
+		t.Fatalf("dstDir.decryptedName(%q) = %q, want %q", encFromSrc, got, name)
+	}
+}
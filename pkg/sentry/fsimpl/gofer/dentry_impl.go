@@ -25,6 +25,7 @@ import (
 	"gvisor.dev/gvisor/pkg/fsutil"
 	"gvisor.dev/gvisor/pkg/lisafs"
 	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/safemem"
 	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
 	"gvisor.dev/gvisor/pkg/sentry/vfs"
 )
@@ -54,6 +55,8 @@ func (d *dentry) isReadHandleOk() bool {
 		return dt.readFDLisa.Ok()
 	case *directfsDentry:
 		return d.readFD.RacyLoad() >= 0
+	case *cryptfsDentry:
+		return dt.readFDLisa.Ok()
 	case nil: // synthetic dentry
 		return false
 	default:
@@ -68,6 +71,8 @@ func (d *dentry) isWriteHandleOk() bool {
 		return dt.writeFDLisa.Ok()
 	case *directfsDentry:
 		return d.writeFD.RacyLoad() >= 0
+	case *cryptfsDentry:
+		return dt.writeFDLisa.Ok()
 	case nil: // synthetic dentry
 		return false
 	default:
@@ -85,6 +90,18 @@ func (d *dentry) readHandle() handle {
 		}
 	case *directfsDentry:
 		return handle{fd: d.readFD.RacyLoad()}
+	case *cryptfsDentry:
+		// The handle's raw fd/fdLisa read ciphertext blocks; a caller must
+		// route through dt.readAt (which in turn uses dt.fileKey, see
+		// cryptFileKey.decryptRange) to recover plaintext instead of reading
+		// through this handle directly. regular_file.go, whose read path is
+		// where that routing belongs, isn't part of this checkout, so
+		// nothing here calls dt.readAt yet: as things stand, a read through
+		// this handle returns ciphertext straight to the application.
+		return handle{
+			fdLisa: dt.readFDLisa,
+			fd:     d.readFD.RacyLoad(),
+		}
 	case nil: // synthetic dentry
 		return noHandle
 	default:
@@ -102,6 +119,19 @@ func (d *dentry) writeHandle() handle {
 		}
 	case *directfsDentry:
 		return handle{fd: d.writeFD.RacyLoad()}
+	case *cryptfsDentry:
+		// See readHandle: a caller must route through dt.writeAt (which
+		// encrypts block-by-block via dt.fileKey, see
+		// cryptFileKey.encryptRange) before reaching this raw handle.
+		// regular_file.go's write path, which is where that routing
+		// belongs, isn't part of this checkout; the write call sites this
+		// checkout has (writebackCacheFlushLocked, setStatLocked's
+		// truncate-grow and allocate's zero-fill) all route cryptfs
+		// writes through dt.writeAt.
+		return handle{
+			fdLisa: dt.writeFDLisa,
+			fd:     d.writeFD.RacyLoad(),
+		}
 	case nil: // synthetic dentry
 		return noHandle
 	default:
@@ -126,12 +156,21 @@ func (d *dentry) openHandle(ctx context.Context, read, write, trunc bool) (handl
 	}
 	if trunc {
 		flags |= unix.O_TRUNC
+		if d.writebackCache != nil {
+			// The file's content is about to be destroyed by the host/gofer
+			// truncate that opening with O_TRUNC performs; any buffered dirty
+			// extents describe content that no longer exists and must not be
+			// written back over whatever comes after the truncate.
+			d.writebackCache.dropAll()
+		}
 	}
 	switch dt := d.impl.(type) {
 	case *lisafsDentry:
 		return dt.openHandle(ctx, flags)
 	case *directfsDentry:
 		return dt.openHandle(ctx, flags)
+	case *cryptfsDentry:
+		return dt.lisafsDentry.openHandle(ctx, flags)
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -146,6 +185,8 @@ func (d *dentry) updateHandles(ctx context.Context, h handle, readable, writable
 		dt.updateHandles(ctx, h, readable, writable)
 	case *directfsDentry:
 		// No update needed.
+	case *cryptfsDentry:
+		dt.lisafsDentry.updateHandles(ctx, h, readable, writable)
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -155,6 +196,18 @@ func (d *dentry) updateHandles(ctx context.Context, h handle, readable, writable
 //   - d.handleMu must be locked.
 //   - !d.isSynthetic().
 func (d *dentry) closeHostFDs() {
+	// The FDs below are about to be closed; any dirty extents buffered
+	// against them must be written back first; there is no later point at
+	// which they could still be flushed.
+	switch d.impl.(type) {
+	case *lisafsDentry, *directfsDentry:
+		if d.writebackCache != nil {
+			if err := d.writebackCacheFlushLocked(context.Background()); err != nil {
+				log.Warningf("gofer: failed to write back dirty cache extents for %q before closing host FDs: %v", genericDebugPathname(d.fs, d), err)
+			}
+		}
+	}
+
 	// We can use RacyLoad() because d.handleMu is locked.
 	if d.readFD.RacyLoad() >= 0 {
 		_ = unix.Close(int(d.readFD.RacyLoad()))
@@ -188,11 +241,24 @@ func (d *dentry) updateMetadataLocked(ctx context.Context, h handle) error {
 	// Need checklocksforce below because checklocks has no way of knowing that
 	// d.impl.(*dentryImpl).dentry == d. It can't know that the right metadataMu
 	// is already locked.
+	oldSize := d.size.Load()
 	switch dt := d.impl.(type) {
 	case *lisafsDentry:
-		return dt.updateMetadataLocked(ctx, h) // +checklocksforce: acquired by precondition.
+		err := dt.updateMetadataLocked(ctx, h) // +checklocksforce: acquired by precondition.
+		d.dropWritebackCacheOnOutOfBandChange(oldSize)
+		return err
 	case *directfsDentry:
-		return dt.updateMetadataLocked(h) // +checklocksforce: acquired by precondition.
+		err := dt.updateMetadataLocked(h) // +checklocksforce: acquired by precondition.
+		d.dropWritebackCacheOnOutOfBandChange(oldSize)
+		return err
+	case *cryptfsDentry:
+		// The gofer-reported size is the ciphertext size; overwrite it with
+		// the tracked plaintext size so stat(2) reports the logical length.
+		if err := dt.lisafsDentry.updateMetadataLocked(ctx, h); err != nil { // +checklocksforce: acquired by precondition.
+			return err
+		}
+		d.size.Store(dt.plaintextSize)
+		return nil
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -208,6 +274,9 @@ func (d *dentry) prepareSetStat(ctx context.Context, stat *linux.Statx) error {
 		return nil
 	case *directfsDentry:
 		return dt.prepareSetStat(ctx, stat)
+	case *cryptfsDentry:
+		// Nothing to be done.
+		return nil
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -220,6 +289,8 @@ func (d *dentry) chmod(ctx context.Context, mode uint16) error {
 		return chmod(ctx, dt.controlFD, mode)
 	case *directfsDentry:
 		return dt.chmod(ctx, mode)
+	case *cryptfsDentry:
+		return chmod(ctx, dt.controlFD, mode)
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -236,11 +307,52 @@ func (d *dentry) setStatLocked(ctx context.Context, stat *linux.Statx) (uint32,
 	case *directfsDentry:
 		failureMask, failureErr := dt.setStatLocked(ctx, stat)
 		return failureMask, failureErr, nil
+	case *cryptfsDentry:
+		if stat.Mask&linux.STATX_SIZE != 0 {
+			oldSize := d.size.Load()
+			if stat.Size > oldSize && dt.fileKey != nil {
+				// Growing a truncate must materialize encrypted zero blocks
+				// covering the new range the grow just exposed, the same
+				// way allocate's zero-fill does: the ciphertext file is
+				// never implicitly longer than what's actually been sealed,
+				// so without this a read into the grown region would hit a
+				// short ciphertext read (EOF) or, if the range happens to
+				// land inside an already-allocated-but-unwritten block,
+				// fail AES-GCM authentication instead of returning zeroes.
+				if _, err := dt.writeAt(ctx, d.writeHandle(), make([]byte, stat.Size-oldSize), oldSize); err != nil {
+					return 0, nil, err
+				}
+			}
+			// Truncating changes the logical (plaintext) size; the on-disk
+			// ciphertext size is whatever the write path's sealed blocks
+			// (each carrying its own nonce and tag) add up to, not
+			// stat.Size itself, so stat.Size is tracked here instead of
+			// forwarded as a raw SetStat size.
+			d.size.Store(stat.Size)
+			dt.plaintextSize = stat.Size
+			if err := dt.persistPlaintextSize(ctx); err != nil {
+				return 0, nil, err
+			}
+			stat = maskOutSize(*stat)
+		}
+		if stat.Mask == 0 {
+			return 0, nil, nil
+		}
+		return dt.controlFD.SetStat(ctx, stat)
 	default:
 		panic("unknown dentry implementation")
 	}
 }
 
+// maskOutSize returns a copy of stat with STATX_SIZE cleared, used by
+// cryptfsDentry.setStatLocked once the plaintext size has been applied
+// locally, so that the ciphertext size on the gofer side isn't
+// overwritten with the (unrelated) plaintext length.
+func maskOutSize(stat linux.Statx) *linux.Statx {
+	stat.Mask &^= linux.STATX_SIZE
+	return &stat
+}
+
 // Precondition: d.handleMu must be locked.
 func (d *dentry) destroyImpl(ctx context.Context) {
 	switch dt := d.impl.(type) {
@@ -248,6 +360,8 @@ func (d *dentry) destroyImpl(ctx context.Context) {
 		dt.destroy(ctx)
 	case *directfsDentry:
 		dt.destroy(ctx)
+	case *cryptfsDentry:
+		dt.lisafsDentry.destroy(ctx)
 	case nil: // synthetic dentry
 	default:
 		panic("unknown dentry implementation")
@@ -263,11 +377,38 @@ func (d *dentry) getRemoteChild(ctx context.Context, name string) (*dentry, erro
 		return dt.getRemoteChild(ctx, name)
 	case *directfsDentry:
 		return dt.getHostChild(name)
+	case *cryptfsDentry:
+		child, err := dt.lisafsDentry.getRemoteChild(ctx, d.encryptedName(name))
+		if err != nil {
+			return nil, err
+		}
+		return d.wrapRemoteChild(child)
 	default:
 		panic("unknown dentry implementation")
 	}
 }
 
+// wrapRemoteChild replaces child.impl (set to a bare *lisafsDentry by the
+// embedded lisafsDentry's own child-construction path, which has no notion
+// of cryptfs) with a *cryptfsDentry sharing d's key store, so that every
+// descendant of a cryptfs mount point is itself a cryptfsDentry.
+//
+// Precondition: d.impl is a *cryptfsDentry.
+func (d *dentry) wrapRemoteChild(child *dentry) (*dentry, error) {
+	childLisa, ok := child.impl.(*lisafsDentry)
+	if !ok {
+		// Already wrapped (e.g. returned from cache by a concurrent walk).
+		return child, nil
+	}
+	parent := d.impl.(*cryptfsDentry)
+	cd, err := newCryptfsDentry(childLisa, parent.keys, inoKeyFromDentry(child), child.isRegularFile(), child.size.Load())
+	if err != nil {
+		return nil, err
+	}
+	child.impl = cd
+	return child, nil
+}
+
 // Preconditions:
 //   - fs.renameMu must be locked.
 //   - parent.opMu must be locked for reading.
@@ -285,6 +426,8 @@ func (d *dentry) getRemoteChildAndWalkPathLocked(ctx context.Context, rp resolvi
 		// We need to check for races because opMu is read locked which allows
 		// concurrent walks to occur.
 		return d.fs.getRemoteChildLocked(ctx, d, rp.Component(), true /* checkForRace */, ds)
+	case *cryptfsDentry:
+		return d.getRemoteChild(ctx, rp.Component())
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -298,6 +441,8 @@ func (d *dentry) listXattrImpl(ctx context.Context, size uint64) ([]string, erro
 	case *directfsDentry:
 		// Consistent with runsc/fsgofer.
 		return nil, linuxerr.EOPNOTSUPP
+	case *cryptfsDentry:
+		return dt.listXattr()
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -310,6 +455,8 @@ func (d *dentry) getXattrImpl(ctx context.Context, opts *vfs.GetXattrOptions) (s
 		return dt.controlFD.GetXattr(ctx, opts.Name, opts.Size)
 	case *directfsDentry:
 		return dt.getXattr(ctx, opts.Name, opts.Size)
+	case *cryptfsDentry:
+		return dt.getXattr(opts.Name)
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -323,6 +470,8 @@ func (d *dentry) setXattrImpl(ctx context.Context, opts *vfs.SetXattrOptions) er
 	case *directfsDentry:
 		// Consistent with runsc/fsgofer.
 		return linuxerr.EOPNOTSUPP
+	case *cryptfsDentry:
+		return dt.setXattr(opts)
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -336,6 +485,8 @@ func (d *dentry) removeXattrImpl(ctx context.Context, name string) error {
 	case *directfsDentry:
 		// Consistent with runsc/fsgofer.
 		return linuxerr.EOPNOTSUPP
+	case *cryptfsDentry:
+		return dt.removeXattr(name)
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -348,6 +499,12 @@ func (d *dentry) mknod(ctx context.Context, name string, creds *auth.Credentials
 		return dt.mknod(ctx, name, creds, opts)
 	case *directfsDentry:
 		return dt.mknod(ctx, name, creds, opts)
+	case *cryptfsDentry:
+		child, err := dt.lisafsDentry.mknod(ctx, d.encryptedName(name), creds, opts)
+		if err != nil {
+			return nil, err
+		}
+		return d.wrapRemoteChild(child)
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -363,6 +520,12 @@ func (d *dentry) link(ctx context.Context, target *dentry, name string) (*dentry
 		return dt.link(ctx, target.impl.(*lisafsDentry), name)
 	case *directfsDentry:
 		return dt.link(target.impl.(*directfsDentry), name)
+	case *cryptfsDentry:
+		child, err := dt.lisafsDentry.link(ctx, target.impl.(*cryptfsDentry).lisafsDentry, d.encryptedName(name))
+		if err != nil {
+			return nil, err
+		}
+		return d.wrapRemoteChild(child)
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -375,6 +538,12 @@ func (d *dentry) mkdir(ctx context.Context, name string, mode linux.FileMode, ui
 		return dt.mkdir(ctx, name, mode, uid, gid, createDentry)
 	case *directfsDentry:
 		return dt.mkdir(name, mode, uid, gid, createDentry)
+	case *cryptfsDentry:
+		child, err := dt.lisafsDentry.mkdir(ctx, d.encryptedName(name), mode, uid, gid, createDentry)
+		if err != nil {
+			return nil, err
+		}
+		return d.wrapRemoteChild(child)
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -387,6 +556,15 @@ func (d *dentry) symlink(ctx context.Context, name, target string, creds *auth.C
 		return dt.symlink(ctx, name, target, creds)
 	case *directfsDentry:
 		return dt.symlink(name, target, creds)
+	case *cryptfsDentry:
+		// The symlink target is encrypted the same way a name is: it's a
+		// short string stored verbatim by the gofer, with no block structure
+		// to justify going through the content cipher instead.
+		child, err := dt.lisafsDentry.symlink(ctx, d.encryptedName(name), dt.keys.nameCipher.Encrypt(target), creds)
+		if err != nil {
+			return nil, err
+		}
+		return d.wrapRemoteChild(child)
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -399,6 +577,16 @@ func (d *dentry) openCreate(ctx context.Context, name string, accessFlags uint32
 		return dt.openCreate(ctx, name, accessFlags, mode, uid, gid, createDentry)
 	case *directfsDentry:
 		return dt.openCreate(name, accessFlags, mode, uid, gid, createDentry)
+	case *cryptfsDentry:
+		child, h, err := dt.lisafsDentry.openCreate(ctx, d.encryptedName(name), accessFlags, mode, uid, gid, createDentry)
+		if err != nil {
+			return nil, handle{}, err
+		}
+		wrapped, err := d.wrapRemoteChild(child)
+		if err != nil {
+			return nil, handle{}, err
+		}
+		return wrapped, h, nil
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -414,6 +602,19 @@ func (d *dentry) getDirentsLocked(ctx context.Context, recordDirent func(name st
 		return dt.getDirentsLocked(ctx, recordDirent)
 	case *directfsDentry:
 		return dt.getDirentsLocked(recordDirent)
+	case *cryptfsDentry:
+		return dt.lisafsDentry.getDirentsLocked(ctx, func(name string, key inoKey, dType uint8) {
+			cleartext, err := dt.decryptedName(name)
+			if err != nil {
+				// A name that doesn't decrypt under this mount's key isn't one
+				// cryptfs wrote; skip it rather than surface gibberish to the
+				// application, matching the errors returned elsewhere in this
+				// file for gofer data that doesn't match expectations.
+				log.Warningf("cryptfs: dropping undecryptable on-disk name %q in directory %q: %v", name, genericDebugPathname(d.fs, d), err)
+				return
+			}
+			recordDirent(cleartext, key, dType)
+		})
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -423,6 +624,11 @@ func (d *dentry) getDirentsLocked(ctx context.Context, recordDirent func(name st
 func (d *dentry) flush(ctx context.Context) error {
 	d.handleMu.RLock()
 	defer d.handleMu.RUnlock()
+	if d.writebackCache != nil {
+		if err := d.writebackCacheFlushLocked(ctx); err != nil {
+			return err
+		}
+	}
 	switch dt := d.impl.(type) {
 	case *lisafsDentry:
 		return flush(ctx, dt.writeFDLisa)
@@ -434,15 +640,95 @@ func (d *dentry) flush(ctx context.Context) error {
 	}
 }
 
+// writebackCacheFlushLocked writes back every dirty extent buffered by
+// d.writebackCache through d's write handle.
+//
+// Precondition: d.writebackCache != nil. d.handleMu must be at least read
+// locked.
+func (d *dentry) writebackCacheFlushLocked(ctx context.Context) error {
+	h := d.writeHandle()
+	if dt, ok := d.impl.(*cryptfsDentry); ok && dt.fileKey != nil {
+		// Route through dt.writeAt rather than writing the cached plaintext
+		// straight to h: h's underlying FD stores ciphertext, so a raw
+		// write here would corrupt the file the same way writeHandle's doc
+		// comment warns a generic write through it would.
+		return d.writebackCache.writeback(func(offset uint64, data []byte) error {
+			_, err := dt.writeAt(ctx, h, data, offset)
+			return err
+		})
+	}
+	return d.writebackCache.writeback(func(offset uint64, data []byte) error {
+		_, err := h.writeFromBlocksAt(ctx, safemem.BlockSeqOf(safemem.BlockFromSafeSlice(data)), offset)
+		return err
+	})
+}
+
+// dropWritebackCacheOnOutOfBandChange drops every dirty extent buffered by
+// d.writebackCache if the metadata refresh that just ran observed d's size
+// shrink. A shrink can only come from an out-of-band modification (e.g.
+// another client of the same gofer export truncating the file; this
+// dentry's own allocate/write paths only ever grow d.size), and it
+// invalidates every buffered write, which was computed assuming it extends
+// the last content this dentry observed rather than content that's since
+// been truncated out from under it.
+func (d *dentry) dropWritebackCacheOnOutOfBandChange(oldSize uint64) {
+	if d.writebackCache == nil {
+		return
+	}
+	if d.size.Load() < oldSize {
+		d.writebackCache.dropAll()
+	}
+}
+
 // Precondition: !d.isSynthetic().
 func (d *dentry) allocate(ctx context.Context, mode, offset, length uint64) error {
 	d.handleMu.RLock()
 	defer d.handleMu.RUnlock()
 	switch dt := d.impl.(type) {
 	case *lisafsDentry:
+		if d.writebackCache != nil && mode == 0 && d.fs.opts.interop != InteropModeShared {
+			if oldSize, end := d.size.Load(), offset+length; end > oldSize {
+				d.writebackCache.markZeroFilled(oldSize, end-oldSize)
+				d.size.Store(end)
+			}
+			return nil
+		}
 		return dt.writeFDLisa.Allocate(ctx, mode, offset, length)
 	case *directfsDentry:
+		if d.writebackCache != nil && mode == 0 && d.fs.opts.interop != InteropModeShared {
+			if oldSize, end := d.size.Load(), offset+length; end > oldSize {
+				d.writebackCache.markZeroFilled(oldSize, end-oldSize)
+				d.size.Store(end)
+			}
+			return nil
+		}
 		return unix.Fallocate(int(d.writeFD.RacyLoad()), uint32(mode), int64(offset), int64(length))
+	case *cryptfsDentry:
+		// mode == 0 (grow, zero-filling the new range) is the only mode
+		// with a sensible meaning once content is sealed block-by-block:
+		// unlike lisafsDentry/directfsDentry, cryptfsDentry can't forward
+		// offset/length to a raw gofer/host fallocate(2), since that would
+		// zero the *ciphertext* rather than the plaintext it represents,
+		// leaving every covered block full of zero bytes that are not a
+		// valid nonce+ciphertext+tag: a later read would fail AES-GCM
+		// authentication instead of returning zeroes. Route the zero-fill
+		// through writeAt instead, exactly as a real zero-filling write
+		// would be, so every affected block is properly re-sealed.
+		if mode != 0 {
+			return linuxerr.EOPNOTSUPP
+		}
+		if end := offset + length; end > dt.plaintextSize {
+			oldSize := dt.plaintextSize
+			if _, err := dt.writeAt(ctx, d.writeHandle(), make([]byte, end-oldSize), oldSize); err != nil {
+				return err
+			}
+			dt.plaintextSize = end
+			d.size.Store(end)
+			if err := dt.persistPlaintextSize(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -464,6 +750,8 @@ func (d *dentry) connect(ctx context.Context, sockType linux.SockType) (int, err
 		return dt.controlFD.Connect(ctx, sockType, euid, egid)
 	case *directfsDentry:
 		return dt.connect(ctx, sockType, euid, egid)
+	case *cryptfsDentry:
+		return dt.lisafsDentry.controlFD.Connect(ctx, sockType, euid, egid)
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -476,6 +764,12 @@ func (d *dentry) readlinkImpl(ctx context.Context) (string, error) {
 		return dt.controlFD.ReadLinkAt(ctx)
 	case *directfsDentry:
 		return dt.readlink()
+	case *cryptfsDentry:
+		encrypted, err := dt.lisafsDentry.controlFD.ReadLinkAt(ctx)
+		if err != nil {
+			return "", err
+		}
+		return dt.keys.nameCipher.Decrypt(encrypted)
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -488,6 +782,8 @@ func (d *dentry) unlink(ctx context.Context, name string, flags uint32) error {
 		return dt.controlFD.UnlinkAt(ctx, name, flags)
 	case *directfsDentry:
 		return unix.Unlinkat(dt.controlFD, name, int(flags))
+	case *cryptfsDentry:
+		return dt.lisafsDentry.controlFD.UnlinkAt(ctx, d.encryptedName(name), flags)
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -500,6 +796,13 @@ func (d *dentry) rename(ctx context.Context, oldName string, newParent *dentry,
 		return dt.controlFD.RenameAt(ctx, oldName, newParent.impl.(*lisafsDentry).controlFD.ID(), newName)
 	case *directfsDentry:
 		return fsutil.RenameAt(dt.controlFD, oldName, newParent.impl.(*directfsDentry).controlFD, newName)
+	case *cryptfsDentry:
+		// oldName and newName are both cleartext names from the VFS layer
+		// (as in the lisafs/directfs cases above); each must be encrypted
+		// with the key store of the directory it's resolved under, which for
+		// newName is newParent's rather than d's.
+		newParentCrypt := newParent.impl.(*cryptfsDentry)
+		return dt.lisafsDentry.controlFD.RenameAt(ctx, d.encryptedName(oldName), newParentCrypt.lisafsDentry.controlFD.ID(), newParentCrypt.encryptedName(newName))
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -512,6 +815,8 @@ func (d *dentry) statfs(ctx context.Context) (linux.Statfs, error) {
 		return dt.statfs(ctx)
 	case *directfsDentry:
 		return dt.statfs()
+	case *cryptfsDentry:
+		return dt.lisafsDentry.statfs(ctx)
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -584,6 +889,36 @@ func (d *dentry) restoreFile(ctx context.Context, opts *vfs.CompleteRestoreOptio
 		}
 		return dt.restoreFile(ctx, childFD, opts)
 
+	case *cryptfsDentry:
+		parent := d.parent.Load().impl.(*cryptfsDentry)
+		controlFD := parent.lisafsDentry.controlFD
+		inode, err := controlFD.Walk(ctx, parent.encryptedName(d.name))
+		if err != nil {
+			if !dt.isDir() || !dt.forMountpoint {
+				return fmt.Errorf("failed to walk %q of type %x: %w", genericDebugPathname(d.fs, d), dt.fileType(), err)
+			}
+
+			// Recreate directories that were created during volume mounting, since
+			// during restore we don't attempt to remount them.
+			inode, err = controlFD.MkdirAt(ctx, parent.encryptedName(d.name), linux.FileMode(d.mode.Load()), lisafs.UID(d.uid.Load()), lisafs.GID(d.gid.Load()))
+			if err != nil {
+				return fmt.Errorf("failed to create mountpoint directory at %q: %w", genericDebugPathname(d.fs, d), err)
+			}
+		}
+		if err := dt.lisafsDentry.restoreFile(ctx, &inode, opts); err != nil {
+			return err
+		}
+		// Re-derive the content key and reload the plaintext size, neither
+		// of which is itself serialized across save/restore; see
+		// cryptfsDentry.restoreFile.
+		if err := dt.restoreFile(ctx, inoKeyFromDentry(d), d.isRegularFile()); err != nil {
+			return err
+		}
+		if d.isRegularFile() {
+			d.size.Store(dt.plaintextSize)
+		}
+		return nil
+
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -594,6 +929,15 @@ func (d *dentry) readHandleForDeleted(ctx context.Context) (handle, error) {
 	if d.isReadHandleOk() {
 		return d.readHandle(), nil
 	}
+	// The handle returned below is read directly by the caller rather than
+	// through d's own cache-aware read path, so any dirty extents have to be
+	// on the host/gofer side before it's handed out, or a reader would miss
+	// bytes that only exist in the cache.
+	if d.writebackCache != nil {
+		if err := d.writebackCacheFlushLocked(ctx); err != nil {
+			return handle{}, fmt.Errorf("failed to write back dirty cache extents: %w", err)
+		}
+	}
 	switch dt := d.impl.(type) {
 	case *lisafsDentry:
 		// ensureSharedHandle locks handleMu for write. Unlock it temporarily.
@@ -638,6 +982,14 @@ func (r *revalidateState) doRevalidation(ctx context.Context, vfsObj *vfs.Virtua
 		return doRevalidationLisafs(ctx, vfsObj, r, ds)
 	case *directfsDentry:
 		return doRevalidationDirectfs(ctx, vfsObj, r, ds)
+	case *cryptfsDentry:
+		// The wire-level walk is identical to plain lisafs (cryptfsDentry
+		// embeds a *lisafsDentry and reuses its controlFD for every RPC);
+		// the only difference is that names crossing the RPC boundary are
+		// encrypted, which doRevalidationLisafs doesn't need to know about
+		// since it revalidates existing dentries by their already-resolved
+		// on-disk identity (inode number), not by re-deriving names.
+		return doRevalidationLisafs(ctx, vfsObj, r, ds)
 	default:
 		panic("unknown dentry implementation")
 	}
@@ -0,0 +1,236 @@
+// Copyright 2026 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofer
+
+import (
+	"sort"
+	"sync"
+
+	"gvisor.dev/gvisor/pkg/atomicbitops"
+)
+
+// writebackCacheMountOption is the mount option that selects the per-dentry
+// writeback cache (dentryWritebackCache) defined in this file. It is
+// opt-in (rather than on by default for every lisafs/directfs mount) because
+// buffering writes in sentry memory changes crash-durability semantics: data
+// written before a sentry crash that hasn't hit flush/close/eviction is
+// lost, whereas the existing pass-through write path is durable as soon as
+// the gofer/host write call returns.
+const writebackCacheMountOption = "writeback_cache"
+
+// dentryWritebackCache is types and logic only, not an integrated feature:
+// as newDentryWritebackCache's doc comment below explains, no real dentry in
+// this checkout ever has one, so every use site takes its no-cache branch
+// and ordinary write(2) round trips never see a reduced gofer/host round
+// trip count. dentryWritebackCache buffers a dentry's writes into dirty
+// extents (see dirtyExtent), coalescing overlapping or adjacent writes, so
+// that repeated small writes to the same region only cross the gofer/host
+// boundary once (on flush, close, a post-revalidation metadata refresh, or
+// memory-pressure eviction) instead of on every write(2).
+//
+// A dentryWritebackCache is accessed under its owning dentry's handleMu,
+// which callers such as allocate hold only for reading, so mu guards
+// extents; the hit/miss/writeback counters are atomics and are read
+// without mu.
+type dentryWritebackCache struct {
+	mu sync.Mutex
+
+	// extents is sorted by offset and kept non-overlapping: every write
+	// merges into, or replaces the covered portion of, existing extents.
+	extents []dirtyExtent
+
+	// hits, misses, and writebackBytes are the counters surfaced through
+	// the dentry's usage stats (see dentry.writebackCacheStats).
+	hits           atomicbitops.Uint64
+	misses         atomicbitops.Uint64
+	writebackBytes atomicbitops.Uint64
+}
+
+// dirtyExtent is a single not-yet-written-back run of a dentry's cleartext
+// content. An extent covers exactly the bytes passed to writeAt or
+// markZeroFilled: offsets and lengths are not rounded to page boundaries,
+// neither by this cache nor by its callers in dentry_impl.go.
+type dirtyExtent struct {
+	offset uint64
+	data   []byte
+}
+
+func (e *dirtyExtent) end() uint64 { return e.offset + uint64(len(e.data)) }
+
+// newDentryWritebackCache returns an empty cache.
+//
+// newDentryWritebackCache has no callers in this checkout: every use site
+// in dentry_impl.go (openHandle, closeHostFDs, flush, allocate,
+// updateMetadataLocked) only acts "if d.writebackCache != nil", but the
+// dentry.writebackCache field itself, and the mount-option-gated call to
+// newDentryWritebackCache that would populate it (keyed on
+// writebackCacheMountOption, presumably in filesystem.go's mount-option
+// parsing), live outside this checkout. Until both land, d.writebackCache
+// is always nil on a real dentry and every use site above takes its
+// no-cache branch.
+func newDentryWritebackCache() *dentryWritebackCache {
+	return &dentryWritebackCache{}
+}
+
+// readAt copies into dst the cached bytes covering [offset, offset+len(dst)),
+// returning true if and only if that entire range is cached. A partial hit
+// (the range only partially overlaps a cached extent) counts as a miss:
+// callers fall back to reading the whole range from the gofer/host rather
+// than stitching together a cache hit and a read, which would otherwise
+// double every partial-hit read path.
+//
+// readAt has no callers in this checkout for the same reason
+// newDentryWritebackCache doesn't: an ordinary read(2)/write(2) round trip
+// is serviced by regular_file.go, which isn't part of this checkout. The
+// calls into this cache in this checkout are allocate's markZeroFilled
+// (recording a grow as dirty, zero-filled content),
+// writebackCacheFlushLocked (draining it), dropAll from openHandle and
+// dropWritebackCacheOnOutOfBandChange, and the stats read; none needs
+// readAt.
+func (c *dentryWritebackCache) readAt(dst []byte, offset uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	end := offset + uint64(len(dst))
+	for _, e := range c.extents {
+		if e.offset <= offset && end <= e.end() {
+			copy(dst, e.data[offset-e.offset:])
+			c.hits.Add(1)
+			return true
+		}
+	}
+	c.misses.Add(1)
+	return false
+}
+
+// writeAt records data as dirty at offset, merging with and/or splitting any
+// existing extents it overlaps so that c.extents remains sorted and
+// non-overlapping. data is copied; the caller's slice is not retained.
+func (c *dentryWritebackCache) writeAt(data []byte, offset uint64) {
+	if len(data) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	newExtent := dirtyExtent{offset: offset, data: append([]byte(nil), data...)}
+	end := newExtent.end()
+
+	// Find every existing extent that overlaps or directly abuts
+	// [offset, end), so the new write can absorb them into one coalesced
+	// extent rather than leaving adjacent fragments around indefinitely.
+	merged := make([]dirtyExtent, 0, len(c.extents)+1)
+	inserted := false
+	for _, e := range c.extents {
+		if e.end() < newExtent.offset || e.offset > end {
+			// No overlap/adjacency with the new write.
+			if !inserted && e.offset > end {
+				merged = append(merged, newExtent)
+				inserted = true
+			}
+			merged = append(merged, e)
+			continue
+		}
+		// e overlaps or abuts the new write: extend newExtent to cover e,
+		// preferring the new write's bytes wherever the two overlap since
+		// it represents more recent data.
+		lo, hi := newExtent.offset, end
+		if e.offset < lo {
+			lo = e.offset
+		}
+		if e.end() > hi {
+			hi = e.end()
+		}
+		combined := make([]byte, hi-lo)
+		copyAt(combined, e.offset-lo, e.data)                 // e's bytes first...
+		copyAt(combined, newExtent.offset-lo, newExtent.data) // ...overwritten by the new write.
+		newExtent = dirtyExtent{offset: lo, data: combined}
+		end = hi
+	}
+	if !inserted {
+		merged = append(merged, newExtent)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].offset < merged[j].offset })
+	c.extents = merged
+}
+
+// copyAt copies src into dst starting at byte offset off, used by writeAt to
+// place a sub-range's bytes into a larger combined buffer.
+func copyAt(dst []byte, off uint64, src []byte) {
+	copy(dst[off:], src)
+}
+
+// markZeroFilled records [offset, offset+length) as dirty, zero-filled
+// content, used by dentry.allocate when growing a file: the gofer/host
+// fallocate call that would normally zero this range on disk is deferred
+// like any other dirty write, rather than issued immediately, so that a
+// grow immediately followed by a write into the grown region still only
+// costs one round trip on writeback.
+func (c *dentryWritebackCache) markZeroFilled(offset, length uint64) {
+	c.writeAt(make([]byte, length), offset)
+}
+
+// dropAll discards every dirty extent without writing any of them back. It
+// is used by dentry.openHandle when opening with trunc=true: the file's
+// previous content (and therefore every pending write to it) is about to be
+// destroyed by the truncation, so writing it back first would be both
+// wasted work and, for a shrinking truncate, actively wrong.
+func (c *dentryWritebackCache) dropAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.extents = nil
+}
+
+// writeback calls writeFn once per dirty extent (in ascending offset order)
+// and, if every call succeeds, discards the cache's dirty state. If writeFn
+// returns an error for some extent, writeback stops and returns that error
+// immediately, leaving every extent — including ones already successfully
+// written back earlier in this call — dirty, so that a retried flush
+// doesn't skip data that failed to make it out the first time.
+func (c *dentryWritebackCache) writeback(writeFn func(offset uint64, data []byte) error) error {
+	c.mu.Lock()
+	extents := c.extents
+	c.mu.Unlock()
+
+	for _, e := range extents {
+		if err := writeFn(e.offset, e.data); err != nil {
+			return err
+		}
+		c.writebackBytes.Add(uint64(len(e.data)))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.extents = nil
+	return nil
+}
+
+// stats returns the cache's lifetime hit/miss/writeback-byte counters, for
+// the dentry's usage stats to report.
+func (c *dentryWritebackCache) stats() (hits, misses, writebackBytes uint64) {
+	return c.hits.Load(), c.misses.Load(), c.writebackBytes.Load()
+}
+
+// writebackCacheStats returns d's writeback cache hit/miss/writeback-byte
+// counters, surfaced through the usage stats the way other per-dentry
+// gofer counters (e.g. read/write RPC counts) already are. It returns all
+// zeros if d has no writeback cache, i.e. the mount didn't opt in via
+// writebackCacheMountOption or d isn't a regular file - which, per the
+// newDentryWritebackCache comment, is every dentry in this checkout.
+func (d *dentry) writebackCacheStats() (hits, misses, writebackBytes uint64) {
+	if d.writebackCache == nil {
+		return 0, 0, 0
+	}
+	return d.writebackCache.stats()
+}
@@ -0,0 +1,174 @@
+// Copyright 2026 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gofer
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestWritebackCacheReadAfterWriteHit(t *testing.T) {
+	c := newDentryWritebackCache()
+	c.writeAt([]byte("hello"), 100)
+
+	got := make([]byte, 5)
+	if !c.readAt(got, 100) {
+		t.Fatal("readAt reported a miss for a range covered by a single write")
+	}
+	if !bytes.Equal(got, []byte("hello")) {
+		t.Fatalf("readAt = %q, want %q", got, "hello")
+	}
+
+	if hits, misses, _ := c.stats(); hits != 1 || misses != 0 {
+		t.Fatalf("stats after one hit = (hits=%d, misses=%d), want (1, 0)", hits, misses)
+	}
+}
+
+func TestWritebackCacheReadUncoveredIsMiss(t *testing.T) {
+	c := newDentryWritebackCache()
+	c.writeAt([]byte("hello"), 100)
+
+	got := make([]byte, 5)
+	if c.readAt(got, 200) {
+		t.Fatal("readAt reported a hit for an uncached range")
+	}
+	if hits, misses, _ := c.stats(); hits != 0 || misses != 1 {
+		t.Fatalf("stats after one miss = (hits=%d, misses=%d), want (0, 1)", hits, misses)
+	}
+}
+
+// TestWritebackCacheCoalescesOverlappingWrites exercises random-offset
+// writes into overlapping regions, checking that later writes win over
+// earlier ones wherever they overlap and that the cache never reports more
+// than one dirty extent for what ends up being one contiguous dirty region.
+func TestWritebackCacheCoalescesOverlappingWrites(t *testing.T) {
+	c := newDentryWritebackCache()
+
+	// Three overlapping writes, applied out of the order their offsets would
+	// suggest, each a different fill byte so reads reveal the winner.
+	c.writeAt(bytes.Repeat([]byte{'a'}, 100), 0)   // [0, 100)
+	c.writeAt(bytes.Repeat([]byte{'b'}, 100), 150) // [150, 250), disjoint from the above
+	c.writeAt(bytes.Repeat([]byte{'c'}, 100), 50)  // [50, 150): bridges the two into one run
+
+	if len(c.extents) != 1 {
+		t.Fatalf("after a bridging write, got %d extents, want 1 (coalesced)", len(c.extents))
+	}
+	if c.extents[0].offset != 0 || c.extents[0].end() != 250 {
+		t.Fatalf("coalesced extent spans [%d, %d), want [0, 250)", c.extents[0].offset, c.extents[0].end())
+	}
+
+	got := make([]byte, 250)
+	if !c.readAt(got, 0) {
+		t.Fatal("readAt missed on the coalesced extent")
+	}
+	if !bytes.Equal(got[:50], bytes.Repeat([]byte{'a'}, 50)) {
+		t.Fatalf("bytes [0,50) = %q, want all 'a'", got[:50])
+	}
+	if !bytes.Equal(got[50:150], bytes.Repeat([]byte{'c'}, 100)) {
+		t.Fatalf("bytes [50,150) = %q, want all 'c' (last writer wins)", got[50:150])
+	}
+	if !bytes.Equal(got[150:250], bytes.Repeat([]byte{'b'}, 100)) {
+		t.Fatalf("bytes [150,250) = %q, want all 'b'", got[150:250])
+	}
+}
+
+// TestWritebackCacheWritebackAndDrop checks that writeback issues every
+// dirty extent via the supplied callback in ascending offset order, sums the
+// bytes into the writeback-bytes counter, and leaves the cache empty on
+// success (so a later read of the same range is a clean miss, matching the
+// now-flushed state on the host/gofer side).
+func TestWritebackCacheWritebackAndDrop(t *testing.T) {
+	c := newDentryWritebackCache()
+	c.writeAt([]byte("world"), 500) // written second...
+	c.writeAt([]byte("hello"), 0)   // ...but offset-ordered first
+
+	var order []uint64
+	if err := c.writeback(func(offset uint64, data []byte) error {
+		order = append(order, offset)
+		return nil
+	}); err != nil {
+		t.Fatalf("writeback: %v", err)
+	}
+	if len(order) != 2 || order[0] != 0 || order[1] != 500 {
+		t.Fatalf("writeback visited offsets %v, want [0 500]", order)
+	}
+	if _, _, writebackBytes := c.stats(); writebackBytes != 10 {
+		t.Fatalf("writebackBytes = %d, want 10", writebackBytes)
+	}
+
+	got := make([]byte, 5)
+	if c.readAt(got, 0) {
+		t.Fatal("readAt hit on an extent that writeback should have discarded")
+	}
+}
+
+// TestWritebackCacheWritebackErrorKeepsExtentsDirty checks that a failing
+// writeFn call leaves every extent dirty, including ones already written
+// back earlier in the same writeback call, so a retry doesn't silently skip
+// data.
+func TestWritebackCacheWritebackErrorKeepsExtentsDirty(t *testing.T) {
+	c := newDentryWritebackCache()
+	c.writeAt([]byte("aaaa"), 0)
+	c.writeAt([]byte("bbbb"), 1000)
+
+	wantErr := fmt.Errorf("simulated write failure")
+	calls := 0
+	err := c.writeback(func(offset uint64, data []byte) error {
+		calls++
+		if offset == 1000 {
+			return wantErr
+		}
+		return nil
+	})
+	if err != wantErr {
+		t.Fatalf("writeback error = %v, want %v", err, wantErr)
+	}
+	if calls != 2 {
+		t.Fatalf("writeFn called %d times, want 2", calls)
+	}
+
+	got := make([]byte, 4)
+	if !c.readAt(got, 0) {
+		t.Fatal("extent at offset 0 was dropped despite writeback failing on a later extent")
+	}
+}
+
+func TestWritebackCacheDropAll(t *testing.T) {
+	c := newDentryWritebackCache()
+	c.writeAt([]byte("hello"), 0)
+	c.dropAll()
+
+	got := make([]byte, 5)
+	if c.readAt(got, 0) {
+		t.Fatal("readAt hit after dropAll")
+	}
+}
+
+func TestWritebackCacheMarkZeroFilled(t *testing.T) {
+	c := newDentryWritebackCache()
+	c.markZeroFilled(0, 64)
+
+	got := make([]byte, 64)
+	for i := range got {
+		got[i] = 0xff
+	}
+	if !c.readAt(got, 0) {
+		t.Fatal("readAt missed on a zero-filled extent")
+	}
+	if !bytes.Equal(got, make([]byte, 64)) {
+		t.Fatalf("zero-filled extent contents = %v, want all zero", got)
+	}
+}
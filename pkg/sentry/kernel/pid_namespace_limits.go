@@ -0,0 +1,220 @@
+// Copyright 2026 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"gvisor.dev/gvisor/pkg/atomicbitops"
+	"gvisor.dev/gvisor/pkg/errors/linuxerr"
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+// PIDNamespaceLimits, and the reservation/release plumbing below it, are
+// types and logic only, not an integrated feature: MaxTasks is not actually
+// enforced yet. PIDNamespaceLimits is meant to be a set of mutable
+// per-PIDNamespace resource quotas, the pids.max-equivalent caps a container
+// runtime sets per sandbox nesting level, with a zero value in any field
+// meaning "unlimited" (matching TasksLimit's historical role as the only
+// global, immutable cap) - but as reserveTaskLocked's doc comment below
+// explains, this checkout has neither the TaskSet.newTask call site that
+// would call reserveTaskLocked nor the task-exit call site that would call
+// releaseTaskLocked, so SetLimits's MaxTasks/MaxThreadGroups/MaxPGIDs are
+// never actually consulted by anything that creates a task, thread group,
+// or process group. (NumTasks and NumTasksPerContainer in threads.go are
+// unaffected by this gap: they count ns.tids directly, independent of the
+// counters here.)
+type PIDNamespaceLimits struct {
+	// MaxTasks is the maximum number of tasks visible in the namespace
+	// (i.e. len(PIDNamespace.tids)), or 0 for unlimited.
+	MaxTasks int64
+
+	// MaxThreadGroups is the maximum number of thread groups visible in the
+	// namespace, or 0 for unlimited.
+	MaxThreadGroups int64
+
+	// MaxPGIDs is the maximum number of process groups visible in the
+	// namespace, or 0 for unlimited.
+	MaxPGIDs int64
+}
+
+// PIDNamespaceUsage is a point-in-time reading of a PIDNamespace's resource
+// counters, as returned by PIDNamespace.Usage.
+type PIDNamespaceUsage struct {
+	Tasks        int64
+	ThreadGroups int64
+	PGIDs        int64
+}
+
+// pidNamespaceLimits holds the mutable limits and atomic usage counters for
+// a PIDNamespace. It is kept as a separate embedded struct (rather than
+// loose fields on PIDNamespace) so that the "mutable limits, atomic
+// counters" pairing central to this file is visually grouped in
+// PIDNamespace's definition.
+type pidNamespaceLimits struct {
+	// mu protects limits. The counter fields below are not protected by mu;
+	// they're updated with atomicbitops so that the hot add/remove paths
+	// don't need to acquire it.
+	mu sync.Mutex `state:"nosave"`
+
+	limits PIDNamespaceLimits
+
+	numTasks        atomicbitops.Int64
+	numThreadGroups atomicbitops.Int64
+	numPGIDs        atomicbitops.Int64
+
+	// containerTasks is protected by mu; the *atomicbitops.Int64 values it
+	// points to are updated atomically, outside mu. Entries are created
+	// lazily by reserveTaskLocked and never removed, since the set of
+	// container IDs that have ever run in a namespace is small and bounded
+	// by the number of containers in the sandbox.
+	containerTasks map[string]*atomicbitops.Int64
+}
+
+// SetLimits replaces ns's resource limits with limits. It does not
+// retroactively enforce the new limits against tasks/thread
+// groups/process groups that already exist; it only takes effect for
+// subsequent allocations.
+func (ns *PIDNamespace) SetLimits(limits PIDNamespaceLimits) {
+	ns.limitsState.mu.Lock()
+	defer ns.limitsState.mu.Unlock()
+	ns.limitsState.limits = limits
+}
+
+// Limits returns ns's current resource limits.
+func (ns *PIDNamespace) Limits() PIDNamespaceLimits {
+	ns.limitsState.mu.Lock()
+	defer ns.limitsState.mu.Unlock()
+	return ns.limitsState.limits
+}
+
+// Usage returns a point-in-time reading of ns's resource counters.
+func (ns *PIDNamespace) Usage() PIDNamespaceUsage {
+	return PIDNamespaceUsage{
+		Tasks:        ns.limitsState.numTasks.Load(),
+		ThreadGroups: ns.limitsState.numThreadGroups.Load(),
+		PGIDs:        ns.limitsState.numPGIDs.Load(),
+	}
+}
+
+// reserveTaskLocked checks ns's task limit, and that of every ancestor
+// namespace (since a task visible in ns is also visible in every ancestor,
+// per PIDNamespace's visibility invariant, an outer limit must cap the sum
+// of tasks contributed by every nested namespace, not just its own
+// immediate children). If every level has room, it increments every
+// level's counter (plus ns's own per-container counter for cid, which
+// NumTasksPerContainer does not read: it still walks ns.tids) and returns
+// nil; if any level is at its
+// limit, no counter is modified and it returns linuxerr.EAGAIN, matching
+// Linux's fork(2) behavior when a pid namespace's pids.max is reached.
+//
+// reserveTaskLocked is meant to be called by whatever assigns ns a new TID
+// (TaskSet.newTask in the upstream tree), and releaseTaskLocked by whatever
+// retires one (task exit cleanup); neither call site exists in this
+// checkout (see the PIDNamespaceLimits doc comment above).
+//
+// Preconditions: ns.owner.mu must be locked for writing (the caller is
+// about to assign a new TID).
+func (ns *PIDNamespace) reserveTaskLocked(cid string) error {
+	for n := ns; n != nil; n = n.parent {
+		if limit := n.Limits().MaxTasks; limit != 0 && n.limitsState.numTasks.Load() >= limit {
+			return linuxerr.EAGAIN
+		}
+	}
+	for n := ns; n != nil; n = n.parent {
+		n.limitsState.numTasks.Add(1)
+	}
+	ns.containerTaskCounter(cid).Add(1)
+	return nil
+}
+
+// releaseTaskLocked undoes a prior successful reserveTaskLocked call for
+// ns, decrementing ns's counter, that of every ancestor namespace, and ns's
+// per-container counter for cid.
+//
+// Preconditions: ns.owner.mu must be locked for writing (the caller is
+// about to remove a TID, e.g. because the task was reaped).
+func (ns *PIDNamespace) releaseTaskLocked(cid string) {
+	for n := ns; n != nil; n = n.parent {
+		n.limitsState.numTasks.Add(-1)
+	}
+	ns.containerTaskCounter(cid).Add(-1)
+}
+
+// containerTaskCounter returns the atomic task counter for cid within ns,
+// creating it if this is the first task seen for cid in ns.
+func (ns *PIDNamespace) containerTaskCounter(cid string) *atomicbitops.Int64 {
+	ns.limitsState.mu.Lock()
+	defer ns.limitsState.mu.Unlock()
+	if ns.limitsState.containerTasks == nil {
+		ns.limitsState.containerTasks = make(map[string]*atomicbitops.Int64)
+	}
+	counter, ok := ns.limitsState.containerTasks[cid]
+	if !ok {
+		counter = new(atomicbitops.Int64)
+		ns.limitsState.containerTasks[cid] = counter
+	}
+	return counter
+}
+
+// reserveThreadGroupLocked is to threadGroupNode creation as
+// reserveTaskLocked is to task creation: see reserveTaskLocked.
+//
+// Preconditions: ns.owner.mu must be locked for writing.
+func (ns *PIDNamespace) reserveThreadGroupLocked() error {
+	for n := ns; n != nil; n = n.parent {
+		if limit := n.Limits().MaxThreadGroups; limit != 0 && n.limitsState.numThreadGroups.Load() >= limit {
+			return linuxerr.EAGAIN
+		}
+	}
+	for n := ns; n != nil; n = n.parent {
+		n.limitsState.numThreadGroups.Add(1)
+	}
+	return nil
+}
+
+// releaseThreadGroupLocked undoes a prior successful
+// reserveThreadGroupLocked call for ns. See releaseTaskLocked.
+//
+// Preconditions: ns.owner.mu must be locked for writing.
+func (ns *PIDNamespace) releaseThreadGroupLocked() {
+	for n := ns; n != nil; n = n.parent {
+		n.limitsState.numThreadGroups.Add(-1)
+	}
+}
+
+// reservePGIDLocked is to ProcessGroup creation as reserveTaskLocked is to
+// task creation: see reserveTaskLocked.
+//
+// Preconditions: ns.owner.mu must be locked for writing.
+func (ns *PIDNamespace) reservePGIDLocked() error {
+	for n := ns; n != nil; n = n.parent {
+		if limit := n.Limits().MaxPGIDs; limit != 0 && n.limitsState.numPGIDs.Load() >= limit {
+			return linuxerr.EAGAIN
+		}
+	}
+	for n := ns; n != nil; n = n.parent {
+		n.limitsState.numPGIDs.Add(1)
+	}
+	return nil
+}
+
+// releasePGIDLocked undoes a prior successful reservePGIDLocked call for
+// ns. See releaseTaskLocked.
+//
+// Preconditions: ns.owner.mu must be locked for writing.
+func (ns *PIDNamespace) releasePGIDLocked() {
+	for n := ns; n != nil; n = n.parent {
+		n.limitsState.numPGIDs.Add(-1)
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2026 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/sentry/ktime"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// PtraceEventKind identifies the kind of an entry in a PtraceEventLog,
+// mirroring the PTRACE_EVENT_* family reported via a ptrace-stop's exit
+// status (see ptrace(2)).
+type PtraceEventKind int
+
+// Event kinds recorded in a PtraceEventLog.
+const (
+	PtraceEventFork PtraceEventKind = iota
+	PtraceEventVfork
+	PtraceEventClone
+	PtraceEventExec
+	PtraceEventExit
+	PtraceEventSeccomp
+	PtraceEventStop
+)
+
+// PtraceEventData is the event-specific payload of a PtraceEvent. Exactly
+// one field is meaningful, selected by the enclosing PtraceEvent's Kind;
+// which one is kept separate from PtraceEvent (rather than, say, an
+// interface{} payload) so that the common fields stay cheap to scan when a
+// tracer is only interested in sequence numbers and kinds, mirroring the
+// PtraceEvent/PtraceEventData split Starnix uses to keep transient
+// PtraceCoreState stop payload out of the event metadata that must survive
+// after the originating task is reaped.
+type PtraceEventData struct {
+	// ChildPID is the new child's TID, for Fork/Vfork/Clone.
+	ChildPID ThreadID
+
+	// ExecPath is the path passed to execve(2), for Exec.
+	ExecPath string
+
+	// ExitCode is the task's reported exit status, for Exit.
+	ExitCode int32
+
+	// SeccompData is the syscall that triggered a PTRACE_EVENT_SECCOMP
+	// stop.
+	SeccompData linux.SeccompData
+}
+
+// PtraceEvent is a single entry in a ThreadGroup's PtraceEventLog.
+type PtraceEvent struct {
+	// Seq is this event's monotonically increasing sequence number, unique
+	// and strictly increasing within a single PtraceEventLog. Tracers use
+	// Seq (via Task.PtraceEventsSince) to resume draining exactly where
+	// they left off, even across coalesced stops.
+	Seq uint64
+
+	// Kind is the kind of event.
+	Kind PtraceEventKind
+
+	// Time is when the event was recorded, per the system's monotonic
+	// clock.
+	Time ktime.Time
+
+	// TID is the originating task's thread ID, translated via the tracer's
+	// own PIDNamespace (which may differ from the tracee's, e.g. across a
+	// PID namespace boundary spanned by PTRACE_SEIZE); 0 if the task is not
+	// visible in that namespace.
+	TID ThreadID
+
+	// Data is the event-specific payload; see PtraceEventData.
+	Data PtraceEventData
+}
+
+// ptraceEventLogCapacity bounds how many PtraceEvents a single
+// PtraceEventLog retains. Once full, the oldest event is dropped to make
+// room for the newest: a tracer slow enough to fall this far behind has
+// already lost the ability to reconstruct exact history, but the log still
+// guarantees no gap in Seq, so a caller of Since can tell from the first
+// returned event's Seq that it was overrun.
+const ptraceEventLogCapacity = 256
+
+// PtraceEventLog is types and logic only, not an integrated feature: it
+// is meant to be a bounded ring buffer of PtraceEvents belonging to a single
+// ThreadGroup, recording the tracee-side history of events a tracer using
+// PTRACE_SEIZE needs to replay in order, even if it's too slow to keep up
+// stop-by-stop - but as recordPtraceEvent's doc comment below explains,
+// none of the task-creation, execve, exit, or seccomp-filter-evaluation
+// call sites that would actually populate one exist in this checkout, so
+// every ThreadGroup's log stays empty outside of direct test calls.
+// Appends and reads are both serialized by a plain mutex; the log is not
+// lock-free.
+//
+// +stateify savable
+type PtraceEventLog struct {
+	// queue is notified whenever an event is appended, so a tracer blocked
+	// in Task.PtraceEventsSince's caller can wake promptly instead of
+	// polling.
+	queue waiter.Queue `state:"nosave"`
+
+	mu sync.Mutex `state:"nosave"`
+
+	// events is the ring buffer contents, oldest first.
+	events []PtraceEvent
+
+	// nextSeq is the Seq that will be assigned to the next appended event.
+	nextSeq uint64
+}
+
+// Append records event, assigning it the next sequence number and
+// evicting the oldest recorded event if the log is at capacity.
+func (l *PtraceEventLog) Append(kind PtraceEventKind, clock ktime.Clock, tid ThreadID, data PtraceEventData) {
+	l.mu.Lock()
+	seq := l.nextSeq
+	l.nextSeq++
+	ev := PtraceEvent{
+		Seq:  seq,
+		Kind: kind,
+		Time: clock.Now(),
+		TID:  tid,
+		Data: data,
+	}
+	if len(l.events) >= ptraceEventLogCapacity {
+		l.events = append(l.events[1:], ev)
+	} else {
+		l.events = append(l.events, ev)
+	}
+	l.mu.Unlock()
+	l.queue.Notify(waiter.ReadableEvents)
+}
+
+// Since returns every recorded event with Seq >= seq, oldest first. If the
+// oldest retained event's Seq is itself greater than seq, the caller has
+// fallen behind far enough that events were evicted; Since still returns
+// what it has (there is no gap within the returned slice), and it is the
+// caller's responsibility to notice via the first returned event's Seq that
+// it was overrun. The result is nil only if no retained event has
+// Seq >= seq.
+func (l *PtraceEventLog) Since(seq uint64) []PtraceEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// events is ordered by Seq, so the first index with Seq >= seq is found
+	// by a linear scan from the front; the log's bounded size keeps this
+	// cheap without needing a binary search.
+	for i, ev := range l.events {
+		if ev.Seq >= seq {
+			out := make([]PtraceEvent, len(l.events)-i)
+			copy(out, l.events[i:])
+			return out
+		}
+	}
+	return nil
+}
+
+// Readiness implements waiter.Waitable.Readiness. The log is readable
+// whenever it is non-empty, since PtraceEventsSince(0) would then return at
+// least one event; callers polling for genuinely new events should compare
+// against the Seq they last observed rather than relying on Readiness
+// alone.
+func (l *PtraceEventLog) Readiness(mask waiter.EventMask) waiter.EventMask {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.events) == 0 {
+		return 0
+	}
+	return waiter.ReadableEvents & mask
+}
+
+// EventRegister implements waiter.Waitable.EventRegister.
+func (l *PtraceEventLog) EventRegister(e *waiter.Entry) {
+	l.queue.EventRegister(e)
+}
+
+// EventUnregister implements waiter.Waitable.EventUnregister.
+func (l *PtraceEventLog) EventUnregister(e *waiter.Entry) {
+	l.queue.EventUnregister(e)
+}
+
+// recordPtraceEvent appends an entry to t's thread group's PtraceEventLog,
+// translating t's TID via tracerNS (the PID namespace of whichever tracer
+// should be able to resolve it; callers pass t.tg.pidns when there is no
+// more specific tracer namespace available, e.g. at exit after the tracer
+// relationship may already be gone).
+//
+// This is meant to be the common integration point called from the newTask
+// (Fork/Vfork/Clone), execve (Exec), and exit (Exit) paths, as well as
+// seccomp filter evaluation (Seccomp); none of those call sites exist in
+// this checkout (there is no task-creation, execve, exit, or filter
+// evaluator file here to modify), so until they're wired up, every
+// ThreadGroup's PtraceEventLog stays empty and PtraceEventsSince never has
+// anything to return.
+func (t *Task) recordPtraceEvent(kind PtraceEventKind, tracerNS *PIDNamespace, data PtraceEventData) {
+	t.tg.ptraceEventLog.Append(kind, t.k.MonotonicClock(), tracerNS.IDOfTask(t), data)
+}
+
+// PtraceEventsSince returns every event recorded in t's thread group's
+// PtraceEventLog with Seq >= seq. A tracer typically calls this with the
+// Seq one past the last event it successfully processed, allowing it to
+// resume draining in order after being descheduled for a while, without
+// losing events to coalesced stops the way polling ptrace-stop status alone
+// would.
+func (t *Task) PtraceEventsSince(seq uint64) []PtraceEvent {
+	return t.tg.ptraceEventLog.Since(seq)
+}
@@ -0,0 +1,151 @@
+// Copyright 2026 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"gvisor.dev/gvisor/pkg/abi/linux"
+)
+
+// SchedPolicy is one of Linux's scheduling policies, as set by
+// sched_setscheduler(2) or sched_setattr(2).
+type SchedPolicy int32
+
+// The scheduling policies recognized by Linux. Values match linux.SCHED_*
+// (see include/uapi/linux/sched.h).
+const (
+	SchedOther    SchedPolicy = linux.SCHED_NORMAL
+	SchedFIFO     SchedPolicy = linux.SCHED_FIFO
+	SchedRR       SchedPolicy = linux.SCHED_RR
+	SchedBatch    SchedPolicy = linux.SCHED_BATCH
+	SchedIdle     SchedPolicy = linux.SCHED_IDLE
+	SchedDeadline SchedPolicy = linux.SCHED_DEADLINE
+)
+
+// IsRealtime returns true if p is one of the realtime policies, for which
+// Linux uses an rt_priority rather than a nice value. SCHED_DEADLINE is
+// realtime too (it has neither a nice value nor an rt_priority, but it must
+// not fall through to the SCHED_OTHER-style setpriority(2) path any more
+// than SCHED_FIFO/SCHED_RR should).
+func (p SchedPolicy) IsRealtime() bool {
+	return p == SchedFIFO || p == SchedRR || p == SchedDeadline
+}
+
+// SchedulerPolicy and the Task/ThreadGroup API below it are types and logic
+// only, not an integrated feature: SchedulerPolicy is meant to be the
+// scheduling policy and parameters of a Task, readable back via
+// sched_getattr(2), sched_getscheduler(2), and sched_getparam(2), and
+// settable via sched_setattr(2)/sched_setscheduler(2) - but none of those
+// three syscalls have entries anywhere, because this checkout has no
+// syscall table at all (no pkg/sentry/syscalls/linux equivalent exists to
+// add them to). Until a syscall table lands in this checkout, the API below
+// can only be driven by internal Go callers (e.g. cgroup cpu.sched_*
+// plumbing) or direct test calls, never by a sandboxed application's own
+// syscalls.
+//
+// +stateify savable
+type SchedulerPolicy struct {
+	// Policy is the scheduling policy (SCHED_OTHER, SCHED_FIFO, etc).
+	Policy SchedPolicy
+
+	// Nice is the task's nice value, in [-20, 19]. Nice is only meaningful
+	// for non-realtime policies (SCHED_OTHER, SCHED_BATCH, SCHED_IDLE).
+	Nice int32
+
+	// RTPriority is the task's static real-time priority, in [1, 99].
+	// RTPriority is only meaningful for realtime policies (SCHED_FIFO,
+	// SCHED_RR).
+	RTPriority uint32
+}
+
+// SchedulerPolicy returns a copy of t's current scheduler policy.
+func (t *Task) SchedulerPolicy() SchedulerPolicy {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.schedPolicy
+}
+
+// SetSchedulerPolicy sets t's scheduler policy to policy, implementing the
+// Go-level side of sched_setscheduler(2) and sched_setattr(2).
+//
+// Beyond recording policy for later sched_getattr(2) readback (which is all
+// gVisor did historically), SetSchedulerPolicy tries to carry policy down to
+// the host, by calling setSchedulerPolicyHost on t's underlying,
+// LockOSThread-pinned task goroutine. setSchedulerPolicyHost is
+// platform-specific: on Linux it calls setpriority(2) and, for realtime
+// policies, sched_setattr(2); elsewhere, or if the host refuses (e.g. we
+// lack CAP_SYS_NICE for a realtime policy), it falls back to recording
+// intent only. Either way t.schedPolicy itself is left set to policy, so
+// sched_getattr(2) always reads back what the application asked for,
+// matching gVisor's historical behavior of not second-guessing requested
+// values.
+//
+// SetSchedulerPolicy, SchedulerPolicy, and ApplyPendingSchedulerPolicy read
+// and write t.schedPolicy/t.schedPolicyPending, which belong on the Task
+// struct defined in task.go; that file, and the task run loop that's
+// supposed to call ApplyPendingSchedulerPolicy on every interrupt, don't
+// exist in this checkout, so until both land, the host scheduling hook
+// below is reachable only via a direct SetSchedulerPolicy call, never via
+// the ThreadGroup-wide deferred-apply path.
+//
+// Preconditions: The caller must be running on the task goroutine for t.
+func (t *Task) SetSchedulerPolicy(policy SchedulerPolicy) error {
+	t.assertTaskGoroutine()
+	t.mu.Lock()
+	t.schedPolicy = policy
+	t.mu.Unlock()
+	return setSchedulerPolicyHost(policy)
+}
+
+// SetSchedulerPolicy sets the scheduler policy of every task in tg to
+// policy. Linux's sched_setscheduler(2)/sched_setattr(2) only ever affect a
+// single thread even when passed a whole thread group's leader's tid; this
+// helper exists for callers (e.g. container runtime policy, cgroup
+// cpu.sched_* plumbing) that intentionally want one policy applied
+// group-wide, and is not itself a syscall implementation. Because applying
+// the host hook requires running on each target task's own goroutine,
+// SetSchedulerPolicy only updates the readback value here and defers the
+// host hook to the next time each task is interrupted and reaches a
+// rescheduling point, via Task.ApplyPendingSchedulerPolicy.
+func (tg *ThreadGroup) SetSchedulerPolicy(policy SchedulerPolicy) error {
+	tg.ForEachTask(func(t *Task) bool {
+		t.mu.Lock()
+		t.schedPolicy = policy
+		t.schedPolicyPending = true
+		t.mu.Unlock()
+		t.interrupt()
+		return true
+	})
+	return nil
+}
+
+// ApplyPendingSchedulerPolicy applies t's currently-stored scheduler policy
+// to the host if ThreadGroup.SetSchedulerPolicy marked one as pending, and
+// clears the pending flag. It must run on t's own goroutine, since only that
+// goroutine can safely touch its host thread's scheduling attributes; no
+// caller in this checkout invokes it (see SetSchedulerPolicy's doc comment).
+//
+// Preconditions: The caller must be running on the task goroutine for t.
+func (t *Task) ApplyPendingSchedulerPolicy() error {
+	t.assertTaskGoroutine()
+	t.mu.Lock()
+	if !t.schedPolicyPending {
+		t.mu.Unlock()
+		return nil
+	}
+	policy := t.schedPolicy
+	t.schedPolicyPending = false
+	t.mu.Unlock()
+	return setSchedulerPolicyHost(policy)
+}
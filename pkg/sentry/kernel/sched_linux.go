@@ -0,0 +1,78 @@
+// Copyright 2026 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package kernel
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// hostSchedAttr mirrors Linux's struct sched_attr (see
+// include/uapi/linux/sched/types.h), as consumed by the sched_setattr(2)
+// syscall. golang.org/x/sys/unix has no wrapper for it, so we lay out the
+// struct ourselves and go through unix.Syscall directly.
+type hostSchedAttr struct {
+	size     uint32
+	policy   uint32
+	flags    uint64
+	nice     int32
+	priority uint32
+	runtime  uint64
+	deadline uint64
+	period   uint64
+}
+
+// setSchedulerPolicyHost translates policy into host thread scheduling
+// hints for the calling goroutine's underlying OS thread, mirroring
+// Starnix's set_thread_role: it lets container workloads that rely on
+// nice/rt priorities (e.g. audio, game servers) see real priority effects
+// under runsc, instead of policy only ever being readable back via
+// sched_getattr(2).
+//
+// Preconditions: The caller must be locked to its OS thread (as task
+// goroutines are) so that the host thread being adjusted is in fact the one
+// backing the task whose policy changed.
+func setSchedulerPolicyHost(policy SchedulerPolicy) error {
+	tid := unix.Gettid()
+
+	if policy.Policy.IsRealtime() {
+		attr := hostSchedAttr{
+			size:     uint32(unsafe.Sizeof(hostSchedAttr{})),
+			policy:   uint32(policy.Policy),
+			priority: policy.RTPriority,
+		}
+		// Most commonly fails with EPERM, for a sandbox without
+		// CAP_SYS_NICE. Don't treat that as fatal to the caller beyond
+		// surfacing it: gVisor still records the requested policy for
+		// sched_getattr(2) readback even if the host refuses to actually
+		// schedule accordingly.
+		_, _, errno := unix.Syscall(unix.SYS_SCHED_SETATTR, uintptr(tid), uintptr(unsafe.Pointer(&attr)), 0)
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	}
+
+	// SCHED_OTHER/BATCH/IDLE all use the nice value, set via setpriority(2)
+	// rather than sched_setattr(2): this is the same call Linux's own
+	// sched_setscheduler(2) falls back on for non-realtime policies, and
+	// avoids requiring CAP_SYS_NICE for the common case of a process merely
+	// lowering or raising its own niceness.
+	return unix.Setpriority(unix.PRIO_PROCESS, tid, int(policy.Nice))
+}
@@ -0,0 +1,26 @@
+// Copyright 2026 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package kernel
+
+// setSchedulerPolicyHost is a no-op on hosts where we have no way to turn a
+// SchedulerPolicy into host thread scheduling hints. The policy is still
+// recorded by the caller for sched_getattr(2) readback; it just has no
+// observable effect on host scheduling.
+func setSchedulerPolicyHost(policy SchedulerPolicy) error {
+	return nil
+}
@@ -0,0 +1,310 @@
+// Copyright 2026 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/errors/linuxerr"
+	"gvisor.dev/gvisor/pkg/sentry/kernel/auth"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// SeccompNotifier is types and logic only, not an integrated feature: it
+// provides the supervisor-facing side of a seccomp user notification
+// listener, created by a seccomp(SECCOMP_SET_MODE_FILTER) call that installs
+// a filter with SECCOMP_FILTER_FLAG_NEW_LISTENER, analogous to Starnix's
+// SeccompNotifierHandle - but this checkout has neither a seccomp filter
+// evaluator to call Notify, nor a ThreadGroup exit path to call
+// exitSeccompNotifier (see its doc comment), nor a seccomp(2) implementation
+// that calls SetSeccompNotifier below. Until those exist, a
+// SeccompNotifier can only be constructed and driven directly by a
+// test; no sandboxed application can reach one.
+//
+// A SeccompNotifier is shared between every task that is subject to the
+// filter it was created for (the filter, and hence the notifier, is
+// inherited across fork/clone and exec) and the single supervisor holding
+// the listener FD returned by seccomp(2). Filtered tasks enqueue a
+// seccompNotif and block in Notify; the supervisor dequeues it with Recv,
+// inspects or mutates task state out-of-band, and wakes the filtered task
+// with Send.
+//
+// +stateify savable
+type SeccompNotifier struct {
+	// queue is notified when a request becomes available to Recv, or when
+	// the notifier is closed; it backs the listener FD's pollability.
+	queue waiter.Queue `state:"nosave"`
+
+	// mu protects the fields below.
+	mu sync.Mutex `state:"nosave"`
+
+	// nextID is the id to assign to the next enqueued request.
+	nextID uint64
+
+	// pending is the FIFO of requests that have been enqueued by Notify but
+	// not yet delivered to the supervisor by Recv, in enqueue order.
+	pending []*seccompNotif
+
+	// outstanding is the set of requests that have been delivered to the
+	// supervisor by Recv but not yet resolved by Send, keyed by id.
+	outstanding map[uint64]*seccompNotif
+
+	// closed is set once the owning ThreadGroup has exited. Once closed, new
+	// requests are rejected and all blocked tasks are woken with ENOSYS.
+	closed bool
+}
+
+// seccompNotif is a single intercepted syscall awaiting supervisor
+// resolution.
+//
+// +stateify savable
+type seccompNotif struct {
+	id    uint64
+	pid   ThreadID
+	cred  *auth.Credentials
+	data  linux.SeccompData
+	addFD bool
+
+	// ready is sent to (without blocking, so it never needs draining more
+	// than once) once result has been set by Send or close. The blocked
+	// task goroutine in Notify waits on ready via t.block, then reads
+	// result; result is never touched concurrently with that read, since
+	// exactly one of Send/close/cancel ever resolves a given seccompNotif.
+	ready  chan struct{}
+	result seccompNotifResult
+}
+
+// seccompNotifResult is the outcome of a seccompNotif delivered back to the
+// blocked task goroutine.
+type seccompNotifResult struct {
+	resp   linux.SeccompNotifResp
+	closed bool
+}
+
+// NewSeccompNotifier returns a new, empty SeccompNotifier.
+func NewSeccompNotifier() *SeccompNotifier {
+	return &SeccompNotifier{
+		outstanding: make(map[uint64]*seccompNotif),
+	}
+}
+
+// Notify enqueues a notification for the syscall described by data, fired by
+// t, and blocks t until the supervisor resolves it with Send, the notifier
+// is closed (because t's thread group has exited), or t is interrupted. On
+// success it returns the supervisor's SeccompNotifResp as sent (its Error
+// and Val carry the syscall's outcome) and a nil error; if the notifier is
+// closed it returns ENOSYS, and if t is interrupted, block's error.
+//
+// The caller (the seccomp filter evaluator, which has already decoded nr and
+// args off of t's register state) supplies data; Notify itself only adds the
+// pid and credentials the supervisor is entitled to see.
+//
+// Preconditions: The caller must be running on the task goroutine for t.
+func (n *SeccompNotifier) Notify(t *Task, data linux.SeccompData) (linux.SeccompNotifResp, error) {
+	nf := &seccompNotif{
+		pid:   t.tg.pidns.IDOfTask(t),
+		cred:  t.Credentials(),
+		data:  data,
+		ready: make(chan struct{}, 1),
+	}
+
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return linux.SeccompNotifResp{}, linuxerr.ENOSYS
+	}
+	n.nextID++
+	nf.id = n.nextID
+	n.pending = append(n.pending, nf)
+	n.mu.Unlock()
+	n.queue.Notify(waiter.ReadableEvents)
+
+	// block on nf.ready (a plain wakeup signal), not on a channel carrying
+	// the result: block's <-chan struct{} parameter only ever receives an
+	// empty struct, so the result itself must be read out of nf separately
+	// once block confirms it has been set.
+	if err := t.block(nf.ready, nil); err != nil {
+		n.cancel(nf)
+		return linux.SeccompNotifResp{}, err
+	}
+	if nf.result.closed {
+		return linux.SeccompNotifResp{}, linuxerr.ENOSYS
+	}
+	return nf.result.resp, nil
+}
+
+// cancel removes nf from n if it has not yet been delivered to, or resolved
+// by, the supervisor. It is called after Notify is interrupted, so that a
+// late Send or Recv doesn't operate on a request nobody is waiting for.
+func (n *SeccompNotifier) cancel(nf *seccompNotif) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i, p := range n.pending {
+		if p == nf {
+			n.pending = append(n.pending[:i], n.pending[i+1:]...)
+			return
+		}
+	}
+	delete(n.outstanding, nf.id)
+}
+
+// Recv implements the SECCOMP_IOCTL_NOTIF_RECV ioctl: it dequeues the oldest
+// pending request and fills out with its contents. It returns ENOENT if no
+// request is pending.
+func (n *SeccompNotifier) Recv(out *linux.SeccompNotif) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.pending) == 0 {
+		return linuxerr.ENOENT
+	}
+	nf := n.pending[0]
+	n.pending = n.pending[1:]
+	n.outstanding[nf.id] = nf
+
+	*out = linux.SeccompNotif{
+		ID:   nf.id,
+		PID:  uint32(nf.pid),
+		Data: nf.data,
+	}
+	return nil
+}
+
+// Send implements the SECCOMP_IOCTL_NOTIF_SEND ioctl: it delivers resp to
+// the task blocked on the outstanding request resp.ID, waking it. It returns
+// ENOENT if no such outstanding request exists (e.g. the requesting task was
+// killed before the supervisor responded).
+func (n *SeccompNotifier) Send(resp *linux.SeccompNotifResp) error {
+	n.mu.Lock()
+	nf, ok := n.outstanding[resp.ID]
+	if ok {
+		delete(n.outstanding, resp.ID)
+	}
+	n.mu.Unlock()
+	if !ok {
+		return linuxerr.ENOENT
+	}
+	nf.result = seccompNotifResult{resp: *resp}
+	nf.ready <- struct{}{}
+	return nil
+}
+
+// IDValid implements the SECCOMP_IOCTL_NOTIF_ID_VALID ioctl: it reports
+// whether id still refers to an outstanding request, i.e. whether the
+// requesting task is still alive and waiting on a response.
+func (n *SeccompNotifier) IDValid(id uint64) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	_, ok := n.outstanding[id]
+	return ok
+}
+
+// AddFDOwner returns the credentials recorded by Notify for the outstanding
+// request id, or ENOENT if id is not outstanding. It does not return the
+// requesting task or its FDTable, installs no file descriptor, and does not
+// resolve the request, so SECCOMP_IOCTL_NOTIF_ADDFD (including
+// SECCOMP_ADDFD_FLAG_SEND) is not implemented by it.
+func (n *SeccompNotifier) AddFDOwner(id uint64) (*auth.Credentials, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	nf, ok := n.outstanding[id]
+	if !ok {
+		return nil, linuxerr.ENOENT
+	}
+	return nf.cred, nil
+}
+
+// Readiness implements waiter.Waitable.Readiness. The listener FD is
+// readable (has a request ready for Recv) whenever pending is non-empty, and
+// is also reported readable (so that poll/epoll can observe EOF-like
+// readiness) once the notifier is closed.
+func (n *SeccompNotifier) Readiness(mask waiter.EventMask) waiter.EventMask {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.pending) == 0 && !n.closed {
+		return 0
+	}
+	return waiter.ReadableEvents & mask
+}
+
+// EventRegister implements waiter.Waitable.EventRegister.
+func (n *SeccompNotifier) EventRegister(e *waiter.Entry) {
+	n.queue.EventRegister(e)
+}
+
+// EventUnregister implements waiter.Waitable.EventUnregister.
+func (n *SeccompNotifier) EventUnregister(e *waiter.Entry) {
+	n.queue.EventUnregister(e)
+}
+
+// close broadcasts to every task currently blocked in Notify (waking them
+// with ENOSYS, matching Linux's behavior when the listener is closed out
+// from under a filtered task) and marks n so that subsequent requests are
+// rejected immediately. Its only caller is ThreadGroup.exitSeccompNotifier,
+// which nothing in this checkout calls (see its doc comment).
+func (n *SeccompNotifier) close() {
+	n.mu.Lock()
+	n.closed = true
+	pending := n.pending
+	n.pending = nil
+	outstanding := n.outstanding
+	n.outstanding = make(map[uint64]*seccompNotif)
+	n.mu.Unlock()
+
+	for _, nf := range pending {
+		nf.result = seccompNotifResult{closed: true}
+		nf.ready <- struct{}{}
+	}
+	for _, nf := range outstanding {
+		nf.result = seccompNotifResult{closed: true}
+		nf.ready <- struct{}{}
+	}
+	n.queue.Notify(waiter.ReadableEvents)
+}
+
+// SeccompNotifier returns tg's current seccomp user-notification listener
+// handle, or nil if none has been installed.
+func (tg *ThreadGroup) SeccompNotifier() *SeccompNotifier {
+	tg.pidns.owner.mu.RLock()
+	defer tg.pidns.owner.mu.RUnlock()
+	return tg.seccompNotifier
+}
+
+// SetSeccompNotifier installs n as tg's seccomp user-notification listener
+// handle, replacing (without closing) any previously-installed handle. No
+// SECCOMP_SET_MODE_FILTER implementation in this checkout calls it.
+func (tg *ThreadGroup) SetSeccompNotifier(n *SeccompNotifier) {
+	tg.pidns.owner.mu.Lock()
+	defer tg.pidns.owner.mu.Unlock()
+	tg.seccompNotifier = n
+}
+
+// exitSeccompNotifier closes tg's seccomp notifier, if any, waking every
+// task still blocked on it and causing its listener FD to report the
+// notifier as permanently closed to its supervisor. It must be called once,
+// when the last task in tg exits.
+//
+// exitSeccompNotifier and Notify are the two integration points a seccomp
+// filter evaluator and a ThreadGroup's exit path are expected to call into;
+// neither of those call sites exists in this checkout (there is no syscall
+// filter evaluator or task exit path here to modify), so this remains
+// reachable only via direct test calls until those call sites land.
+func (tg *ThreadGroup) exitSeccompNotifier() {
+	tg.pidns.owner.mu.RLock()
+	n := tg.seccompNotifier
+	tg.pidns.owner.mu.RUnlock()
+	if n != nil {
+		n.close()
+	}
+}
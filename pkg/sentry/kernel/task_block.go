@@ -21,6 +21,7 @@ import (
 
 	"gvisor.dev/gvisor/pkg/errors/linuxerr"
 	"gvisor.dev/gvisor/pkg/sentry/ktime"
+	"gvisor.dev/gvisor/pkg/sentry/ktime/wheel"
 	"gvisor.dev/gvisor/pkg/sync"
 	"gvisor.dev/gvisor/pkg/waiter"
 )
@@ -87,6 +88,13 @@ func (t *Task) BlockWithDeadline(C <-chan struct{}, haveDeadline bool, deadline
 	return t.blockWithDeadlineFromSampledClock(C, t.Kernel().MonotonicClock(), deadline)
 }
 
+// blockingTimerPool caches Timers used by BlockWithDeadlineFrom for clocks
+// that are not ktime.SampledClocks (SampledClocks go through the shared
+// wheel instead, see blockWithDeadlineFromSampledClock); it has no other
+// users in this package. Without pooling, every call would pay for a fresh
+// NewTimer/Destroy pair on what can be a hot syscall-blocking path.
+var blockingTimerPool ktime.TimerPool
+
 // BlockWithDeadlineFrom is similar to BlockWithDeadline, except it uses the
 // passed clock (instead of application monotonic clock).
 //
@@ -102,44 +110,41 @@ func (t *Task) BlockWithDeadlineFrom(C <-chan struct{}, clock ktime.Clock, haveD
 		return t.blockWithDeadlineFromSampledClock(C, c, deadline)
 	}
 
-	// Start the timeout timer.
-	timer := clock.NewTimer(t.blockingTimerListener)
-	defer timer.Destroy()
+	// Start the timeout timer, reusing a pooled one if available. The pool is
+	// keyed by (clock, listener): t.blockingTimerListener is fixed for t's
+	// lifetime, so this only ever recycles a timer that already wakes t, not
+	// one left over from some other task that last used this clock.
+	timer := blockingTimerPool.Get(clock, t.blockingTimerListener)
+	defer blockingTimerPool.Put(clock, t.blockingTimerListener, timer, t.blockingTimerChan)
 	timer.Set(ktime.Setting{
 		Enabled: true,
 		Next:    deadline,
 	}, nil)
 
-	err := t.block(C, t.blockingTimerChan)
-
-	// Stop the timeout timer and drain the channel. If s.Enabled is true, the
-	// timer didn't fire yet, so t.blockingTimerChan must be empty.
-	if _, s := timer.Set(ktime.Setting{}, nil); !s.Enabled {
-		select {
-		case <-t.blockingTimerChan:
-		default:
-		}
-	}
-
-	return err
+	return t.block(C, t.blockingTimerChan)
 }
 
+// blockWithDeadlineFromSampledClock registers with clock's shared wheel
+// instead of arming a private per-task timer: with tens of thousands of
+// tasks, most idle at any instant, a live ktime.Timer per task is wasteful,
+// while the wheel multiplexes every deadline wait onto one goroutine. It
+// reuses t.blockingTimerChan (rather than allocating a fresh channel per
+// call) as the wheel's wakeCh, since this is a hot path and the channel
+// serves exactly the same purpose here as it does for the ktime.Timer path
+// in BlockWithDeadlineFrom.
 func (t *Task) blockWithDeadlineFromSampledClock(C <-chan struct{}, clock ktime.SampledClock, deadline ktime.Time) error {
-	// Start the timeout timer.
-	t.blockingTimer.SetClock(clock, ktime.Setting{
-		Enabled: true,
-		Next:    deadline,
-	})
+	w := wheel.ForClock(clock)
+	wakeCh := t.blockingTimerChan
+	h := w.Register(deadline, wakeCh)
 
-	err := t.block(C, t.blockingTimerChan)
+	err := t.block(C, wakeCh)
 
-	// Stop the timeout timer and drain the channel. If s.Enabled is true, the
-	// timer didn't fire yet, so t.blockingTimerChan must be empty.
-	if _, s := t.blockingTimer.Set(ktime.Setting{}, nil); !s.Enabled {
-		select {
-		case <-t.blockingTimerChan:
-		default:
-		}
+	// Deregister in O(1). If the wheel already fired before Cancel could
+	// take effect, drain the wakeup so it doesn't leak into t's next sleep.
+	w.Cancel(h)
+	select {
+	case <-wakeCh:
+	default:
 	}
 
 	return err
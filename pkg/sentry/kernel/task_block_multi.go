@@ -0,0 +1,72 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"gvisor.dev/gvisor/pkg/sentry/ktime"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// BlockOnAny blocks t until one of entries becomes ready, the application
+// monotonic clock indicates a time of deadline (only if haveDeadline is
+// true), or t is interrupted. On success, it returns the index into
+// entries of a ready Waitable (the lowest index, if more than one is
+// ready). On failure, it returns -1 and ETIMEDOUT or
+// linuxerr.ErrInterrupted.
+//
+// BlockOnAny registers every entry against a single shared channel, so
+// callers that wait on many Waitables at once (poll, select, epoll_wait,
+// ppoll) pay O(1) allocations and a single channel receive per syscall,
+// rather than building one waiter.Entry and channel (and, historically,
+// one goroutine) per fd.
+//
+// Preconditions: The caller must be running on the task goroutine.
+func (t *Task) BlockOnAny(entries []waiter.EntrySpec, haveDeadline bool, deadline ktime.Time) (int, error) {
+	if len(entries) == 1 {
+		// Fast path: avoid MultiChannelEntry's bookkeeping when there's
+		// nothing to fan in.
+		return t.blockOnAnySingle(entries[0], haveDeadline, deadline)
+	}
+
+	var mc waiter.MultiChannelEntry
+	mc.Init(entries)
+	defer mc.Release()
+
+	for {
+		if i := mc.ReadyIndex(); i >= 0 {
+			return i, nil
+		}
+		if err := t.BlockWithDeadline(mc.Ready, haveDeadline, deadline); err != nil {
+			return -1, err
+		}
+	}
+}
+
+func (t *Task) blockOnAnySingle(spec waiter.EntrySpec, haveDeadline bool, deadline ktime.Time) (int, error) {
+	if spec.W.Readiness(spec.Mask) != 0 {
+		return 0, nil
+	}
+	e, ch := waiter.NewChannelEntry(spec.Mask)
+	spec.W.EventRegister(&e)
+	defer spec.W.EventUnregister(&e)
+	for {
+		if err := t.BlockWithDeadline(ch, haveDeadline, deadline); err != nil {
+			return -1, err
+		}
+		if spec.W.Readiness(spec.Mask) != 0 {
+			return 0, nil
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"time"
+
+	"gvisor.dev/gvisor/pkg/abi/linux"
+	"gvisor.dev/gvisor/pkg/errors/linuxerr"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// BlockRestartOpts selects how Task.BlockRestartable should translate an
+// interrupt into a returned error, mirroring Linux's distinction between
+// syscalls that transparently restart (ERESTARTSYS, ERESTARTNOHAND,
+// ERESTART_RESTARTBLOCK) and syscalls that must surface EINTR to the
+// application. The zero value behaves like RestartIfNoHandler(); use one of
+// the constructors below to make the choice explicit.
+type BlockRestartOpts struct {
+	kind  blockRestartKind
+	block SyscallRestartBlock
+}
+
+type blockRestartKind int
+
+const (
+	blockRestartIfNoHandler blockRestartKind = iota
+	blockRestartAlways
+	blockRestartBlock
+	blockNoRestart
+)
+
+// RestartIfNoHandler returns ERESTARTSYS (restart transparently) if the
+// interrupting signal has SA_RESTART set, or if it has no user-installed
+// handler at all (Linux restarts syscalls uninterrupted by handler
+// dispositions, since there is no handler return for the syscall to
+// observe). Otherwise it behaves like NoRestart.
+func RestartIfNoHandler() BlockRestartOpts {
+	return BlockRestartOpts{kind: blockRestartIfNoHandler}
+}
+
+// RestartAlways returns ERESTARTSYS regardless of the interrupting
+// signal's disposition. Use this for syscalls (e.g. most slow device reads)
+// that Linux always restarts when interrupted by a handler without
+// SA_RESTART, by virtue of returning ERESTARTNOINTR-like semantics; callers
+// that specifically need Linux's ERESTARTNOHAND distinction should use
+// RestartIfNoHandler instead.
+func RestartAlways() BlockRestartOpts {
+	return BlockRestartOpts{kind: blockRestartAlways}
+}
+
+// RestartBlock returns ERESTART_RESTARTBLOCK and arranges for block to be
+// invoked by a subsequent restart_syscall(2), via
+// Task.SetSyscallRestartBlock.
+func RestartBlock(block SyscallRestartBlock) BlockRestartOpts {
+	return BlockRestartOpts{kind: blockRestartBlock, block: block}
+}
+
+// NoRestart always surfaces EINTR on interrupt, matching the historical
+// behavior of Task.Block.
+func NoRestart() BlockRestartOpts {
+	return BlockRestartOpts{kind: blockNoRestart}
+}
+
+// BlockRestartable is equivalent to Task.Block, except that on interrupt it
+// translates the error according to opts instead of unconditionally
+// returning linuxerr.ErrInterrupted. Callers such as nanosleep, futex, and
+// read on slow devices can use this to stop hand-rolling SA_RESTART logic
+// themselves.
+//
+// Preconditions: The caller must be running on the task goroutine.
+func (t *Task) BlockRestartable(C <-chan struct{}, opts BlockRestartOpts) error {
+	if err := t.block(C, nil); err != nil {
+		if err == linuxerr.ErrInterrupted {
+			return t.restartError(opts)
+		}
+		return err
+	}
+	return nil
+}
+
+// restartError returns the errno that should be surfaced to the
+// application in place of EINTR, per opts and the signal(s) currently
+// pending against t. restartError acquires t.tg.signalHandlers.mu itself
+// (via nextPendingSignalIsRestartable); it does not require any lock to be
+// held on entry.
+func (t *Task) restartError(opts BlockRestartOpts) error {
+	switch opts.kind {
+	case blockRestartAlways:
+		return linuxerr.ERESTARTSYS
+	case blockRestartBlock:
+		t.SetSyscallRestartBlock(opts.block)
+		return linuxerr.ERESTART_RESTARTBLOCK
+	case blockNoRestart:
+		return linuxerr.EINTR
+	case blockRestartIfNoHandler:
+		if t.nextPendingSignalIsRestartable() {
+			return linuxerr.ERESTARTSYS
+		}
+		return linuxerr.EINTR
+	default:
+		panic("BlockRestartOpts with unknown kind")
+	}
+}
+
+// BlockOnRestartable is like BlockOn, but surfaces the restart-aware error
+// produced by BlockRestartable (see opts) instead of collapsing every
+// interrupt to a boolean.
+func (t *Task) BlockOnRestartable(w waiter.Waitable, mask waiter.EventMask, opts BlockRestartOpts) error {
+	e, ch := waiter.NewChannelEntry(mask)
+	w.EventRegister(&e)
+	defer w.EventUnregister(&e)
+	return t.BlockRestartable(ch, opts)
+}
+
+// BlockWithTimeoutRestartable is equivalent to BlockWithTimeout, except that
+// on interrupt it translates the error according to opts instead of
+// returning linuxerr.ErrInterrupted.
+func (t *Task) BlockWithTimeoutRestartable(C chan struct{}, haveTimeout bool, timeout time.Duration, opts BlockRestartOpts) (time.Duration, error) {
+	left, err := t.BlockWithTimeout(C, haveTimeout, timeout)
+	if err == linuxerr.ErrInterrupted {
+		err = t.restartError(opts)
+	}
+	return left, err
+}
+
+// BlockWithTimeoutOnRestartable is like BlockWithTimeoutOn, but surfaces the
+// restart-aware error produced by BlockWithTimeoutRestartable instead of a
+// boolean.
+func (t *Task) BlockWithTimeoutOnRestartable(w waiter.Waitable, mask waiter.EventMask, timeout time.Duration, opts BlockRestartOpts) (time.Duration, error) {
+	e, ch := waiter.NewChannelEntry(mask)
+	w.EventRegister(&e)
+	defer w.EventUnregister(&e)
+	return t.BlockWithTimeoutRestartable(ch, true, timeout, opts)
+}
+
+// nextPendingSignalIsRestartable returns true if the signal that would
+// actually be delivered to t next either carries SA_RESTART, or has no
+// user-installed handler (SIG_DFL/SIG_IGN), in which case Linux restarts
+// the interrupted syscall transparently.
+//
+// A signal blocked in t's signal mask cannot be the one that interrupted
+// the blocking wait (dequeueing skips it), so it must be excluded from
+// consideration here; scanning pending signals without regard to the mask
+// can pick a lower-numbered but undeliverable signal over the
+// higher-numbered, unblocked one that actually interrupted t.
+func (t *Task) nextPendingSignalIsRestartable() bool {
+	pending := t.PendingSignals()
+	mask := t.SignalMask()
+	t.tg.signalHandlers.mu.Lock()
+	defer t.tg.signalHandlers.mu.Unlock()
+	for sig := linux.Signal(1); sig <= linux.SignalMaximum; sig++ {
+		if !pending.Get(sig) {
+			continue
+		}
+		if mask.Get(sig) && sig != linux.SIGKILL && sig != linux.SIGSTOP {
+			// Blocked, and not one of the two signals that can't be
+			// blocked: this signal isn't a candidate for what actually
+			// interrupted t.
+			continue
+		}
+		act := t.tg.signalHandlers.actions[sig]
+		if act.Handler == linux.SIG_DFL || act.Handler == linux.SIG_IGN {
+			return true
+		}
+		return act.Flags&linux.SA_RESTART != 0
+	}
+	// No deliverable pending signal found; treat conservatively as
+	// non-restartable.
+	return false
+}
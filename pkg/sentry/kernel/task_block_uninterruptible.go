@@ -0,0 +1,101 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kernel
+
+import (
+	"time"
+
+	"gvisor.dev/gvisor/pkg/errors/linuxerr"
+	"gvisor.dev/gvisor/pkg/sentry/ktime"
+	"gvisor.dev/gvisor/pkg/sentry/ktime/wheel"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// watchdogTouchInterval bounds how long BlockUninterruptibleWithDeadline can
+// go without calling touchGostateTime, so that a legitimately long but
+// bounded uninterruptible wait (e.g. a disk flush guarded by its own
+// watchdog-sized timeout) is never mistaken for a stuck task goroutine.
+const watchdogTouchInterval = 10 * time.Second
+
+// BlockUninterruptibleWithDeadline blocks t, uninterruptibly, until an
+// event is received from C or the application monotonic clock indicates a
+// time of deadline. It returns nil or ETIMEDOUT; unlike Task.block, it
+// never returns linuxerr.ErrInterrupted, since t does not respond to
+// signals while blocked this way.
+//
+// Unlike UninterruptibleSleepStart/Finish, which only bracket goroutine
+// accounting around a wait the caller builds itself,
+// BlockUninterruptibleWithDeadline owns the timer plumbing: it registers
+// with the same shared timing wheel as the interruptible deadline path
+// (see blockWithDeadlineFromSampledClock), and touches the watchdog at
+// watchdogTouchInterval so a legitimately long wait doesn't get flagged as
+// a stuck task goroutine.
+//
+// Preconditions: The caller must be running on the task goroutine.
+func (t *Task) BlockUninterruptibleWithDeadline(C <-chan struct{}, deadline ktime.Time) error {
+	select {
+	case <-C:
+		return nil
+	default:
+	}
+
+	clock := t.Kernel().MonotonicClock()
+	w := wheel.ForClock(clock)
+	wakeCh := make(chan struct{}, 1)
+	h := w.Register(deadline, wakeCh)
+	defer func() {
+		w.Cancel(h)
+		select {
+		case <-wakeCh:
+		default:
+		}
+	}()
+
+	t.UninterruptibleSleepStart(true)
+	defer t.UninterruptibleSleepFinish(true)
+
+	return t.waitUninterruptibleTouchingWatchdog(C, wakeCh)
+}
+
+// waitUninterruptibleTouchingWatchdog blocks until a value is received from
+// C (returning nil) or wakeCh (returning ETIMEDOUT), calling
+// t.touchGostateTime() at least once per watchdogTouchInterval in the
+// meantime.
+func (t *Task) waitUninterruptibleTouchingWatchdog(C, wakeCh <-chan struct{}) error {
+	ticker := time.NewTicker(watchdogTouchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-C:
+			return nil
+		case <-wakeCh:
+			return linuxerr.ETIMEDOUT
+		case <-ticker.C:
+			t.touchGostateTime()
+		}
+	}
+}
+
+// BlockUninterruptibleOn registers a waiter.Entry for w/mask and blocks on
+// it uninterruptibly until ready or deadline, mirroring how BlockOn wraps
+// Block.
+//
+// Preconditions: The caller must be running on the task goroutine.
+func (t *Task) BlockUninterruptibleOn(w waiter.Waitable, mask waiter.EventMask, deadline ktime.Time) error {
+	e, ch := waiter.NewChannelEntry(mask)
+	w.EventRegister(&e)
+	defer w.EventUnregister(&e)
+	return t.BlockUninterruptibleWithDeadline(ch, deadline)
+}
@@ -36,6 +36,11 @@ import (
 // Note that because of the way futexes are implemented, there *are* in fact
 // serious restrictions on valid thread IDs. They are limited to 2^30 - 1
 // (kernel/fork.c:MAX_THREADS).
+//
+// TasksLimit is the default PIDNamespace.MaxTasks for every new PID
+// namespace, root or child; container runtimes that want a pids.max-equivalent cap tighter
+// than this global default should call PIDNamespace.SetLimits instead of
+// relying on this constant alone.
 const TasksLimit = (1 << 16)
 
 // ThreadID is a generic thread identifier.
@@ -118,15 +123,25 @@ func newTaskSet(pidns *PIDNamespace) *TaskSet {
 func (ts *TaskSet) ForEachThreadGroup(f func(tg *ThreadGroup, tgLeader *Task)) {
 	ts.mu.RLock()
 	defer ts.mu.RUnlock()
-	ts.forEachThreadGroupLocked(f)
-}
-
-// forEachThreadGroupLocked applies f to each thread group in ts.
+	ts.forEachThreadGroupLocked(func(tg *ThreadGroup, tgLeader *Task) bool {
+		f(tg, tgLeader)
+		return true
+	})
+}
+
+// forEachThreadGroupLocked applies f to each thread group in ts, stopping
+// early (without visiting any further thread groups) the first time f
+// returns false. This is what lets a caller like TaskSet.Snapshot honor
+// ctx cancellation by shortening the walk itself, rather than merely
+// skipping the per-group work for thread groups visited after
+// cancellation is observed.
 //
 // Preconditions: ts.mu must be locked (for reading or writing).
-func (ts *TaskSet) forEachThreadGroupLocked(f func(tg *ThreadGroup, tgLeader *Task)) {
+func (ts *TaskSet) forEachThreadGroupLocked(f func(tg *ThreadGroup, tgLeader *Task) bool) {
 	for tg := range ts.Root.tgids {
-		f(tg, tg.leader)
+		if !f(tg, tg.leader) {
+			return
+		}
 	}
 }
 
@@ -211,6 +226,12 @@ type PIDNamespace struct {
 	extra pidNamespaceData
 
 	inode *nsfs.Inode
+
+	// limitsState holds ns's mutable resource limits (MaxTasks,
+	// MaxThreadGroups, MaxPGIDs) and the atomic counters that track usage
+	// against them; see PIDNamespaceLimits and PIDNamespace.reserveTaskLocked
+	// et al.
+	limitsState pidNamespaceLimits
 }
 
 func newPIDNamespace(ts *TaskSet, parent *PIDNamespace, userns *auth.UserNamespace) *PIDNamespace {
@@ -227,6 +248,9 @@ func newPIDNamespace(ts *TaskSet, parent *PIDNamespace, userns *auth.UserNamespa
 		processGroups: make(map[ProcessGroupID]*ProcessGroup),
 		pgids:         make(map[*ProcessGroup]ProcessGroupID),
 		extra:         newPIDNamespaceData(),
+		limitsState: pidNamespaceLimits{
+			limits: PIDNamespaceLimits{MaxTasks: TasksLimit},
+		},
 	}
 }
 
@@ -347,13 +371,24 @@ func (ns *PIDNamespace) TasksAppend(ts []*Task) []*Task {
 }
 
 // NumTasks returns the number of tasks in ns.
+//
+// This counts ns.tids directly rather than reading limitsState.numTasks:
+// the latter is only accurate once whatever adds or removes a task from ns
+// (principally TaskSet.newTask and a task's exit cleanup) calls
+// PIDNamespace.reserveTaskLocked/releaseTaskLocked when it mutates
+// ns.tids, and neither call site exists in this checkout (there is no
+// task-creation or task-exit file here to modify), so limitsState.numTasks
+// itself stays 0 and MaxTasks is not actually enforced until that wiring
+// lands.
 func (ns *PIDNamespace) NumTasks() int {
 	ns.owner.mu.RLock()
 	defer ns.owner.mu.RUnlock()
 	return len(ns.tids)
 }
 
-// NumTasksPerContainer returns the number of tasks in ns that belongs to given container.
+// NumTasksPerContainer returns the number of tasks in ns that belongs to
+// given container. See the NumTasks comment: this walks ns.tids rather than
+// reading the same currently-unwired containerTaskCounter.
 func (ns *PIDNamespace) NumTasksPerContainer(cid string) int {
 	ns.owner.mu.RLock()
 	defer ns.owner.mu.RUnlock()
@@ -467,6 +502,25 @@ type threadGroupNode struct {
 	// activeTasks is protected by both the TaskSet mutex and the signal mutex,
 	// as with tasks.
 	activeTasks int
+
+	// seccompNotifier is the handle created by the most recent
+	// seccomp(SECCOMP_SET_MODE_FILTER) call in this thread group that
+	// installed a filter with the SECCOMP_FILTER_FLAG_NEW_LISTENER flag, or
+	// nil if no such filter has been installed. Filters carrying the
+	// SECCOMP_RET_USER_NOTIF action attach to seccompNotifier so that a
+	// supervisor holding the associated listener FD can observe and respond
+	// to intercepted syscalls.
+	//
+	// seccompNotifier is protected by the TaskSet mutex.
+	seccompNotifier *SeccompNotifier
+
+	// ptraceEventLog records this thread group's PTRACE_EVENT_* history for
+	// tracers using PTRACE_SEIZE. Unlike the other fields in this struct,
+	// ptraceEventLog has its own internal mutex (see PtraceEventLog) rather
+	// than being protected by the TaskSet mutex, since it is appended to
+	// from task goroutines that may not otherwise need to take that mutex
+	// for writing.
+	ptraceEventLog PtraceEventLog
 }
 
 // PIDNamespace returns the PID namespace containing tg.
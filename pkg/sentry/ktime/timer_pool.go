@@ -0,0 +1,88 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ktime
+
+import (
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+// TimerPool caches Timers keyed by the (Clock, Listener) pair that created
+// them, so that hot paths which need a short-lived one-shot Timer (e.g.
+// blocking with a deadline on a non-SampledClock) don't pay for a
+// NewTimer/Destroy pair on every call.
+//
+// A Timer's Listener is bound for the Timer's lifetime by NewTimer and can't
+// be rebound later, so a Timer is only ever recycled back to the same
+// Listener that created it (in practice, the same task's
+// blockingTimerListener): keying purely by Clock would let a Get from one
+// task return a Timer still wired to deliver wakeups to a different task's
+// channel.
+//
+// A Timer obtained from Get must eventually be returned via Put by the same
+// caller; Timers are not safe for concurrent reuse, so a given Timer must
+// not be handed out by Get again until the matching Put has completed.
+//
+// TimerPool is safe for concurrent use.
+type TimerPool struct {
+	mu   sync.Mutex
+	free map[timerPoolKey][]Timer
+}
+
+// timerPoolKey identifies the (Clock, Listener) pair a pooled Timer was
+// constructed for.
+type timerPoolKey struct {
+	clock    Clock
+	listener Listener
+}
+
+// Get returns a Timer driven by clock and bound to listener. The returned
+// Timer is either newly allocated or recycled from the pool; in both cases
+// its Setting is disabled and it is ready to be armed with Set.
+func (p *TimerPool) Get(clock Clock, listener Listener) Timer {
+	key := timerPoolKey{clock: clock, listener: listener}
+	p.mu.Lock()
+	if timers := p.free[key]; len(timers) > 0 {
+		n := len(timers) - 1
+		timer := timers[n]
+		p.free[key] = timers[:n]
+		p.mu.Unlock()
+		// The timer's Setting was already cleared by the matching Put, and
+		// its Listener was fixed at NewTimer time to listener (the only
+		// Listener this key's free list ever holds timers for).
+		return timer
+	}
+	p.mu.Unlock()
+	return clock.NewTimer(listener)
+}
+
+// Put cancels timer, drains C (the channel fed by timer's listener) if the
+// timer expired before it could be cancelled, and returns timer to the pool
+// for reuse by a future Get against the same clock and listener. timer must
+// not be used after Put returns.
+func (p *TimerPool) Put(clock Clock, listener Listener, timer Timer, C <-chan struct{}) {
+	if _, s := timer.Set(Setting{}, nil); !s.Enabled {
+		select {
+		case <-C:
+		default:
+		}
+	}
+	key := timerPoolKey{clock: clock, listener: listener}
+	p.mu.Lock()
+	if p.free == nil {
+		p.free = make(map[timerPoolKey][]Timer)
+	}
+	p.free[key] = append(p.free[key], timer)
+	p.mu.Unlock()
+}
@@ -0,0 +1,44 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wheel
+
+import (
+	"gvisor.dev/gvisor/pkg/sentry/ktime"
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+// registry lazily creates and caches a single Wheel per SampledClock, so
+// that every Task blocking against the same Kernel's monotonic clock shares
+// one wheel goroutine instead of each caller standing up its own.
+var registry struct {
+	mu     sync.Mutex
+	wheels map[ktime.SampledClock]*Wheel
+}
+
+// ForClock returns the shared Wheel driven by clock, creating it on first
+// use.
+func ForClock(clock ktime.SampledClock) *Wheel {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	if registry.wheels == nil {
+		registry.wheels = make(map[ktime.SampledClock]*Wheel)
+	}
+	if w, ok := registry.wheels[clock]; ok {
+		return w
+	}
+	w := New(clock)
+	registry.wheels[clock] = w
+	return w
+}
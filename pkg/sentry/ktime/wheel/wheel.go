@@ -0,0 +1,454 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wheel provides a hashed hierarchical timing wheel that
+// multiplexes a large number of deadline waits onto a single goroutine and
+// a single underlying time.Timer.
+//
+// The motivating use case is Task.block's deadline path: with tens of
+// thousands of tasks, giving each one its own live ktime.Timer is wasteful
+// when the overwhelming majority are idle at any given instant. A Wheel
+// lets callers register a (deadline, wakeCh) pair and deregister it in O(1)
+// without touching the host timer APIs at all.
+package wheel
+
+import (
+	"container/list"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/atomicbitops"
+	"gvisor.dev/gvisor/pkg/sentry/ktime"
+	"gvisor.dev/gvisor/pkg/sync"
+)
+
+// tickDuration is the granularity of the wheel's near-term ring. Deadlines
+// are rounded up to the nearest tick, so callers should not rely on
+// sub-tick precision.
+const tickDuration = time.Millisecond
+
+// numSlots is the number of slots in the near-term ring. A full revolution
+// of the ring spans numSlots*tickDuration of clock time; waiters with a
+// deadline further out than that are parked in an overflow list and
+// cascaded into the ring a revolution at a time, which is the standard
+// technique for bounding a hashed wheel's memory to O(live waiters) instead
+// of O(live waiters * deadline range / tick).
+const numSlots = 512
+
+// waiter is a single registered deadline wait. list and elem track where
+// waiter currently lives (one of Wheel.slots or Wheel.overflow) so that
+// Cancel and cascading can find and remove it in O(1); both are guarded by
+// the owning Wheel's mu, and are nil once waiter has fired or been
+// cancelled.
+//
+// Because waiterPool recycles a waiter across Wheels, a Handle obtained
+// from one Register call can outlive the waiter's tenure with that Wheel:
+// by the time a caller gets around to calling Cancel, putWaiter may have
+// already returned the same *waiter to the pool and a second, unrelated
+// Register (possibly on a different Wheel) may have claimed it. epoch
+// guards against exactly that: it is bumped (via an atomic op, so it's
+// safe to read from Cancel without holding the new owner's mu) every time
+// the waiter goes back to the pool, and Cancel treats any epoch mismatch
+// against the value captured at Register time as "already fired or
+// recycled" without touching list/elem, which may belong to a wheel other
+// than the one Cancel was called on.
+type waiter struct {
+	deadline ktime.Time
+	wakeCh   chan<- struct{}
+	list     *list.List
+	elem     *list.Element
+	epoch    atomicbitops.Uint64
+}
+
+// waiterPool recycles waiters across Wheels, since Register/fire is a hot
+// path (once per Task.block deadline wait) and a waiter carries no state
+// that needs to survive beyond the wait it was allocated for.
+var waiterPool = sync.Pool{
+	New: func() any { return &waiter{} },
+}
+
+// Handle identifies a waiter registered with a Wheel via Register. It must
+// be passed to Cancel to deregister the wait.
+type Handle struct {
+	wt    *waiter
+	epoch uint64
+}
+
+// Wheel is a hashed hierarchical timing wheel driven by a single
+// ktime.SampledClock. Construct one with New.
+//
+// A Wheel's runner goroutine only exists while the Wheel has at least one
+// registered waiter: Register starts it on the empty-to-nonempty
+// transition, and it exits once it observes the Wheel empty again. This
+// keeps an idle Wheel (the common case for a Kernel with few pending
+// deadline waits) from holding a goroutine and a live time.Timer at all.
+//
+// A Wheel's runner goroutine and time.Timer are not themselves
+// save/restore-aware: a save must occur with every Task blocked (and
+// therefore every Wheel either idle or about to become so), and restore
+// relies on Register lazily restarting the runner against the restored
+// clock, rather than on any saved goroutine state.
+type Wheel struct {
+	clock ktime.SampledClock
+
+	mu       sync.Mutex
+	slots    [numSlots]list.List
+	overflow list.List
+	// curTick is the index, counted in tickDuration units from epoch, of the
+	// next tick this Wheel has yet to process. Unlike a plain incrementing
+	// slot counter driven by wall-clock timer fires, curTick is derived from
+	// clock.Now() every time it advances, so a waiter is never swept past
+	// its slot just because clock (which may sample slower than, faster
+	// than, or behind real time) hasn't reached its deadline yet.
+	curTick int64
+	// epoch is the reference point curTick is computed from; it is fixed at
+	// the clock's time as of New so that tick indices don't depend on
+	// wall-clock start time.
+	epoch ktime.Time
+	// revs is the number of additional full ring revolutions a waiter
+	// parked in overflow must wait out before cascadeLocked re-inserts it.
+	// Waiters in a ring slot are absent.
+	revs map[*waiter]int64
+
+	running bool
+	stop    chan struct{}
+	wake    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// New creates a Wheel driven by clock. Its runner goroutine is started
+// lazily by the first Register call and exits whenever the Wheel becomes
+// empty, so New itself starts nothing.
+func New(clock ktime.SampledClock) *Wheel {
+	w := &Wheel{
+		clock: clock,
+		epoch: clock.Now(),
+		revs:  make(map[*waiter]int64),
+		wake:  make(chan struct{}, 1),
+	}
+	w.overflow.Init()
+	for i := range w.slots {
+		w.slots[i].Init()
+	}
+	return w
+}
+
+// currentTickLocked returns the index of the tick that t currently falls
+// within (i.e. the latest tick that is no later than t), used to decide how
+// far the ring has progressed as of t.
+//
+// Preconditions: w.mu is locked.
+func (w *Wheel) currentTickLocked(t ktime.Time) int64 {
+	return int64(t.Sub(w.epoch) / tickDuration)
+}
+
+// deadlineTickLocked returns the index of the first tick by which deadline
+// has definitely passed, used to place a waiter: rounding up (rather than
+// down, as currentTickLocked does) ensures a waiter is never fired before
+// its deadline actually arrives.
+//
+// Preconditions: w.mu is locked.
+func (w *Wheel) deadlineTickLocked(deadline ktime.Time) int64 {
+	d := deadline.Sub(w.epoch)
+	tick := int64(d / tickDuration)
+	if d%tickDuration != 0 {
+		tick++
+	}
+	return tick
+}
+
+// Register arranges for a value to be sent on wakeCh (without blocking, so
+// wakeCh should be buffered by at least 1) once the clock reaches deadline.
+// It returns a Handle that Cancel can later use to deregister in O(1).
+//
+// Register does not itself check whether deadline has already passed;
+// callers on the fast path should check before calling Register.
+func (w *Wheel) Register(deadline ktime.Time, wakeCh chan<- struct{}) Handle {
+	wt := waiterPool.Get().(*waiter)
+	wt.deadline = deadline
+	wt.wakeCh = wakeCh
+	epoch := wt.epoch.Load()
+
+	w.mu.Lock()
+	wasEmpty := w.emptyLocked()
+	if wasEmpty {
+		// Nothing has been due since we last had a waiter (or ever); jump
+		// straight to the current time instead of replaying every
+		// intervening empty tick.
+		w.curTick = w.currentTickLocked(w.clock.Now())
+	}
+	w.insertLocked(wt)
+	needStart := wasEmpty && !w.running
+	if needStart {
+		w.running = true
+		w.stop = make(chan struct{})
+		w.stopped.Add(1)
+	}
+	w.mu.Unlock()
+
+	if needStart {
+		go w.run()
+	} else {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	return Handle{wt: wt, epoch: epoch}
+}
+
+// emptyLocked returns whether the Wheel has no registered waiters at all.
+//
+// Preconditions: w.mu is locked.
+func (w *Wheel) emptyLocked() bool {
+	if w.overflow.Len() > 0 {
+		return false
+	}
+	for i := range w.slots {
+		if w.slots[i].Len() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// insertLocked places wt into the appropriate slot or the overflow list
+// based on its deadline relative to the wheel's current tick.
+//
+// Preconditions: w.mu is locked.
+func (w *Wheel) insertLocked(wt *waiter) {
+	deadlineTick := w.deadlineTickLocked(wt.deadline)
+	ticksAway := deadlineTick - w.curTick
+	if ticksAway < 0 {
+		ticksAway = 0
+	}
+	if ticksAway >= numSlots {
+		wt.list = &w.overflow
+		wt.elem = w.overflow.PushBack(wt)
+		w.revs[wt] = ticksAway / numSlots
+		return
+	}
+	slot := int(((w.curTick + ticksAway) % numSlots + numSlots) % numSlots)
+	wt.list = &w.slots[slot]
+	wt.elem = w.slots[slot].PushBack(wt)
+}
+
+// Cancel deregisters h in O(1). It is a no-op if the wait has already
+// fired (the caller can tell the two cases apart by whether wakeCh has
+// already been sent to), and likewise a no-op if h.wt has already been
+// recycled by the pool into an unrelated Register call (possibly on a
+// different Wheel) by the time Cancel runs - see waiter.epoch.
+//
+// Cancel does not drain wakeCh; a caller that races Cancel against a fire
+// must still perform the usual non-blocking drain afterwards, exactly as
+// with a conventional one-shot timer.
+func (w *Wheel) Cancel(h Handle) {
+	if h.wt.epoch.Load() != h.epoch {
+		// Already fired and recycled; checking before taking w.mu avoids
+		// touching list/elem, which may by now belong to a different Wheel
+		// entirely.
+		return
+	}
+	w.mu.Lock()
+	wt := h.wt
+	if wt.epoch.Load() != h.epoch || wt.list == nil {
+		// Fired (and possibly recycled elsewhere) between the check above
+		// and taking w.mu, or already fired by fireSlotLocked under this
+		// same w.mu.
+		w.mu.Unlock()
+		return
+	}
+	wt.list.Remove(wt.elem)
+	delete(w.revs, wt)
+	wt.list = nil
+	wt.elem = nil
+	wt.epoch.Add(1)
+	w.mu.Unlock()
+	w.putWaiter(wt)
+}
+
+// putWaiter resets wt and returns it to waiterPool. It does not touch
+// wt.epoch: its callers (Cancel and fireSlotLocked) bump wt.epoch before
+// calling it, which is what lets Cancel tell a live registration apart from
+// a stale Handle pointing at a since-recycled waiter.
+func (w *Wheel) putWaiter(wt *waiter) {
+	wt.wakeCh = nil
+	wt.deadline = ktime.Time{}
+	waiterPool.Put(wt)
+}
+
+// run is the wheel's runner goroutine. It sleeps on a single time.Timer
+// sized to the next non-empty slot (or, if only the overflow list is
+// non-empty, to the tick at which the ring next completes a revolution and
+// cascades it in) rather than polling at a fixed rate, and exits once the
+// wheel has no registered waiters left, so an idle Wheel costs nothing.
+func (w *Wheel) run() {
+	defer w.stopped.Done()
+	timer := time.NewTimer(0)
+	if !timer.Stop() {
+		<-timer.C
+	}
+	armed := false
+	disarm := func() {
+		if armed {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			armed = false
+		}
+	}
+	defer disarm()
+	for {
+		w.mu.Lock()
+		d, ok := w.nextWakeLocked()
+		done := !ok && w.emptyLocked()
+		if done {
+			w.running = false
+		}
+		w.mu.Unlock()
+		if done {
+			return
+		}
+		disarm()
+		if ok {
+			timer.Reset(d)
+			armed = true
+		}
+		select {
+		case <-w.stop:
+			w.mu.Lock()
+			w.running = false
+			w.mu.Unlock()
+			return
+		case <-w.wake:
+			continue
+		case <-timer.C:
+			armed = false
+		}
+		w.advance()
+	}
+}
+
+// Stop terminates the wheel's runner goroutine, if one is currently
+// running, and waits for it to exit. It is safe to call on a Wheel whose
+// runner has already exited on its own (because the Wheel became empty);
+// in that case Stop is a no-op. A stopped Wheel can still be used
+// afterwards: Register restarts the runner on the next empty-to-nonempty
+// transition.
+func (w *Wheel) Stop() {
+	w.mu.Lock()
+	if !w.running {
+		w.mu.Unlock()
+		return
+	}
+	stopCh := w.stop
+	w.mu.Unlock()
+	close(stopCh)
+	w.stopped.Wait()
+}
+
+// nextWakeLocked returns the duration from clock.Now() until the wheel's
+// runner next needs to do something, and false if nothing is registered.
+//
+// Preconditions: w.mu is locked.
+func (w *Wheel) nextWakeLocked() (time.Duration, bool) {
+	nowTick := w.currentTickLocked(w.clock.Now())
+	aheadBy := nowTick - w.curTick
+	if aheadBy < 0 {
+		aheadBy = 0
+	}
+	for i := int64(0); i < numSlots; i++ {
+		slot := int((w.curTick + i) % numSlots)
+		if w.slots[slot].Len() > 0 {
+			ticks := i - aheadBy
+			if ticks < 0 {
+				ticks = 0
+			}
+			return time.Duration(ticks) * tickDuration, true
+		}
+	}
+	if w.overflow.Len() > 0 {
+		ticks := int64(numSlots) - (w.curTick % numSlots) - aheadBy
+		if ticks < 0 {
+			ticks = 0
+		}
+		return time.Duration(ticks) * tickDuration, true
+	}
+	return 0, false
+}
+
+// advance processes every tick up to and including the tick clock.Now()
+// currently falls within, firing due waiters and cascading the overflow
+// list in whenever the ring completes a revolution.
+func (w *Wheel) advance() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.clock.Now()
+	target := w.currentTickLocked(now)
+	for w.curTick <= target {
+		w.fireSlotLocked(int(w.curTick%numSlots), now)
+		w.curTick++
+		if w.curTick%numSlots == 0 {
+			w.cascadeLocked()
+		}
+	}
+}
+
+// fireSlotLocked fires every waiter in the given slot whose deadline is no
+// later than now, leaving the rest (landed early due to tick rounding) for
+// a later pass.
+//
+// Preconditions: w.mu is locked.
+func (w *Wheel) fireSlotLocked(slot int, now ktime.Time) {
+	s := &w.slots[slot]
+	var next *list.Element
+	for e := s.Front(); e != nil; e = next {
+		next = e.Next()
+		wt := e.Value.(*waiter)
+		if wt.deadline.After(now) {
+			continue
+		}
+		s.Remove(e)
+		wt.list = nil
+		wt.elem = nil
+		wt.epoch.Add(1)
+		select {
+		case wt.wakeCh <- struct{}{}:
+		default:
+		}
+		w.putWaiter(wt)
+	}
+}
+
+// cascadeLocked moves every waiter in the overflow list that has completed
+// its remaining revolutions into the ring.
+//
+// Preconditions: w.mu is locked.
+func (w *Wheel) cascadeLocked() {
+	var next *list.Element
+	for e := w.overflow.Front(); e != nil; e = next {
+		next = e.Next()
+		wt := e.Value.(*waiter)
+		w.revs[wt]--
+		if w.revs[wt] > 0 {
+			continue
+		}
+		delete(w.revs, wt)
+		w.overflow.Remove(e)
+		w.insertLocked(wt)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wheel
+
+import (
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sentry/ktime"
+)
+
+// fakeClock is a minimal ktime.SampledClock backed by a manually advanced
+// time, so tests don't depend on wall-clock scheduling.
+type fakeClock struct {
+	now ktime.Time
+}
+
+func (c *fakeClock) Now() ktime.Time { return c.now }
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: ktime.FromNanoseconds(0)}
+}
+
+func TestImmediateExpiry(t *testing.T) {
+	clock := newFakeClock()
+	w := New(clock)
+	defer w.Stop()
+
+	// A deadline that has already passed a sub-tick ago should still be
+	// delivered promptly; Register doesn't special-case this, so the
+	// caller (Task.block in the real integration) is expected to check
+	// first, but the wheel must still fire it on its very next tick rather
+	// than leaving it pending for a full revolution.
+	wakeCh := make(chan struct{}, 1)
+	w.Register(clock.Now(), wakeCh)
+
+	select {
+	case <-wakeCh:
+	case <-time.After(time.Second):
+		t.Fatal("sub-tick deadline was never delivered")
+	}
+}
+
+func TestCancelPreventsFire(t *testing.T) {
+	clock := newFakeClock()
+	w := New(clock)
+	defer w.Stop()
+
+	wakeCh := make(chan struct{}, 1)
+	h := w.Register(clock.Now().Add(time.Hour), wakeCh)
+	w.Cancel(h)
+
+	select {
+	case <-wakeCh:
+		t.Fatal("cancelled waiter fired")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestCancelAfterFireIsNoop(t *testing.T) {
+	clock := newFakeClock()
+	w := New(clock)
+	defer w.Stop()
+
+	wakeCh := make(chan struct{}, 1)
+	h := w.Register(clock.Now(), wakeCh)
+
+	select {
+	case <-wakeCh:
+	case <-time.After(time.Second):
+		t.Fatal("waiter never fired")
+	}
+
+	// Cancelling a waiter that already fired must not panic or corrupt
+	// wheel state; this is the cancel-vs-fire race that a real Task can
+	// hit if it's interrupted or times out at the same instant the wheel
+	// goroutine delivers the wakeup.
+	w.Cancel(h)
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package waiter
+
+// EntrySpec bundles a Waitable with the event mask that should be
+// monitored on it. It's used by multi-wait helpers such as
+// MultiChannelEntry and kernel.Task.BlockOnAny to describe a set of
+// Waitables to wait on together.
+type EntrySpec struct {
+	W    Waitable
+	Mask EventMask
+}
+
+// MultiChannelEntry fans in readiness notifications from any number of
+// Waitables onto a single channel. It lets callers that need to wait on
+// many Waitables at once (e.g. poll, select, epoll_wait) register once and
+// block on one channel receive, rather than maintaining one Entry/channel
+// pair per Waitable.
+//
+// The zero value is not usable; call Init before use.
+type MultiChannelEntry struct {
+	// Ready is sent to (without blocking, so it never needs draining more
+	// than once) whenever any registered Waitable becomes ready.
+	Ready chan struct{}
+
+	specs   []EntrySpec
+	entries []Entry
+}
+
+// Init registers specs against e's shared channel. Init must be called
+// before any other method, and Release must be called exactly once when e
+// is no longer needed.
+func (e *MultiChannelEntry) Init(specs []EntrySpec) {
+	e.Ready = make(chan struct{}, 1)
+	e.specs = specs
+	e.entries = make([]Entry, len(specs))
+	cb := &channelCallback{ch: e.Ready}
+	for i := range specs {
+		e.entries[i] = Entry{mask: specs[i].Mask, Callback: cb}
+		specs[i].W.EventRegister(&e.entries[i])
+	}
+}
+
+// ReadyIndex returns the index into the specs passed to Init of a Waitable
+// that currently has one of its requested events ready, or -1 if none do.
+// Since e.Ready only indicates that *something* became ready at some point
+// (not which spec, and not that it's still ready now), callers should loop
+// on ReadyIndex after every receive from Ready rather than assuming the
+// first ready index found is still accurate by the time they act on it.
+func (e *MultiChannelEntry) ReadyIndex() int {
+	for i, spec := range e.specs {
+		if spec.W.Readiness(spec.Mask) != 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// Release unregisters every Entry created by Init. It must be called
+// exactly once, including when a wait using e was interrupted or timed
+// out.
+func (e *MultiChannelEntry) Release() {
+	for i := range e.entries {
+		e.specs[i].W.EventUnregister(&e.entries[i])
+	}
+}